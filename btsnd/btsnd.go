@@ -0,0 +1,104 @@
+/*
+Package btsnd implements decoding of the boot sound used by Nintendo Wii-U
+titles. The format is a short header followed by 48 kHz 16-bit big-endian
+stereo PCM samples.
+*/
+package btsnd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// SampleRate is the sample rate of every boot sound
+	SampleRate = 48000
+	// Channels is the number of interleaved channels
+	Channels = 2
+	// BitsPerSample is the size of each sample
+	BitsPerSample = 16
+)
+
+type header struct {
+	_         uint32
+	LoopStart uint32
+}
+
+// Sound represents a decoded boot sound.
+type Sound struct {
+	// LoopStart is the sample frame the sound loops back to
+	LoopStart uint32
+	// Samples are the interleaved left and right channel samples
+	Samples []int16
+}
+
+// Decode reads a boot sound from r.
+func Decode(r io.Reader) (*Sound, error) {
+	h := header{}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%(Channels*BitsPerSample/8) != 0 {
+		return nil, errors.New("btsnd: truncated sample")
+	}
+
+	s := &Sound{
+		LoopStart: h.LoopStart,
+		Samples:   make([]int16, len(b)/2),
+	}
+	for i := range s.Samples {
+		s.Samples[i] = int16(binary.BigEndian.Uint16(b[i*2:]))
+	}
+
+	return s, nil
+}
+
+// WriteWAV writes the sound to w as a PCM WAV file.
+func (s *Sound) WriteWAV(w io.Writer) error {
+	const blockAlign = Channels * BitsPerSample / 8
+
+	size := uint32(len(s.Samples) * 2)
+
+	h := struct {
+		RIFF          [4]byte
+		RIFFSize      uint32
+		WAVE          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		RIFFSize:      36 + size,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1,
+		Channels:      Channels,
+		SampleRate:    SampleRate,
+		ByteRate:      SampleRate * blockAlign,
+		BlockAlign:    blockAlign,
+		BitsPerSample: BitsPerSample,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      size,
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, s.Samples)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/btsnd"
+	"github.com/bodgit/wud/tga"
+	"github.com/spf13/afero"
+)
+
+func artwork(name, common, game, directory string, parents []string, convert bool) error {
+	w, c, err := openWUD(name, common, game, parents)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	directory = filepath.Join(directory, w.ProductCode())
+	if err = fs.MkdirAll(directory, os.ModePerm|os.ModeDir); err != nil {
+		return err
+	}
+
+	for _, file := range []string{wud.IconTexFile, wud.BootTvTexFile, wud.BootDrcTexFile, wud.BootLogoTexFile, wud.BootSoundFile} {
+		b, err := w.ReadFile(file)
+		if err != nil {
+			// Not every title has every file
+			if errors.Is(err, wud.ErrFileNotFound) {
+				continue
+			}
+			return err
+		}
+
+		target := filepath.Join(directory, path.Base(file))
+
+		if convert {
+			buf := new(bytes.Buffer)
+			switch path.Ext(file) {
+			case ".tga":
+				img, err := tga.Decode(bytes.NewReader(b))
+				if err != nil {
+					return err
+				}
+				if err = png.Encode(buf, img); err != nil {
+					return err
+				}
+				target = strings.TrimSuffix(target, ".tga") + ".png"
+			case ".btsnd":
+				s, err := btsnd.Decode(bytes.NewReader(b))
+				if err != nil {
+					return err
+				}
+				if err = s.WriteWAV(buf); err != nil {
+					return err
+				}
+				target = strings.TrimSuffix(target, ".btsnd") + ".wav"
+			}
+			b = buf.Bytes()
+		}
+
+		partial := target + wud.PartialExtension
+		err = afero.WriteFile(fs, partial, b, 0666)
+		if err = finishTarget(partial, target, false, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/wux"
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+)
+
+type batchResult struct {
+	file     string
+	err      error
+	skipped  bool
+	duration time.Duration
+}
+
+// isUncompressed returns whether name is an uncompressed image, only the
+// first part of a split image counts.
+func isUncompressed(name string) bool {
+	base := filepath.Base(name)
+	return filepath.Ext(base) == wud.Extension && (!strings.HasPrefix(base, "game_part") || isSplit(name))
+}
+
+// isCompressed returns whether name is a compressed image.
+func isCompressed(name string) bool {
+	return filepath.Ext(name) == wux.Extension
+}
+
+// isImage returns whether name is any type of image.
+func isImage(name string) bool {
+	return isUncompressed(name) || isCompressed(name)
+}
+
+// expandSources expands any glob patterns in args and, if recursive is set,
+// walks any directories for files that satisfy match.
+func expandSources(args []string, recursive bool, match func(string) bool) ([]string, error) {
+	files := []string{}
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			files = append(files, name)
+		}
+	}
+
+	for _, arg := range args {
+		if isURL(arg) {
+			add(arg)
+			continue
+		}
+
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = afero.Glob(fs, arg); err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matches", arg)
+			}
+		}
+
+		for _, m := range matches {
+			fi, err := fs.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+
+			if !fi.IsDir() {
+				add(m)
+				continue
+			}
+
+			if !recursive {
+				return nil, fmt.Errorf("%s is a directory", m)
+			}
+
+			found := []string{}
+			if err = afero.Walk(fs, m, func(name string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if fi.Mode().IsRegular() && match(name) {
+					found = append(found, name)
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			sort.Strings(found)
+
+			for _, f := range found {
+				add(f)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// runBatch calls fn for each file using up to jobs goroutines. Any errors
+// are aggregated and, for more than one file, a summary is printed.
+func runBatch(files []string, jobs int, fn func(string) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]batchResult, len(files))
+	sem := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, file string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			start := time.Now()
+			err := fn(file)
+			skipped := errors.Is(err, errSkipped)
+			if skipped {
+				err = nil
+			}
+			results[i] = batchResult{file, err, skipped, time.Since(start)}
+		}(i, file)
+	}
+	wg.Wait()
+
+	var err error
+	for _, r := range results {
+		if r.err != nil {
+			err = multierror.Append(err, fmt.Errorf("%s: %w", r.file, r.err))
+		}
+	}
+
+	if len(files) > 1 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tRESULT\tTIME")
+		for _, r := range results {
+			result := "ok"
+			switch {
+			case r.err != nil:
+				result = r.err.Error()
+			case r.skipped:
+				result = "skipped"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.file, result, r.duration.Round(time.Second))
+		}
+		if e := tw.Flush(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+
+	return err
+}
+
+// splitTarget separates an optional trailing target from the sources. This
+// is only possible with one source, the target must have the extension ext.
+func splitTarget(args []string, ext string) ([]string, string) {
+	if len(args) == 2 && filepath.Ext(args[1]) == ext && !strings.ContainsAny(args[1], "*?[") {
+		return args[:1], args[1]
+	}
+	return args, ""
+}
+
+// isSource returns whether arg names images rather than a key file, either
+// directly, as a glob pattern or as a directory.
+func isSource(arg string) bool {
+	if isImage(arg) || strings.ContainsAny(arg, "*?[") {
+		return true
+	}
+	fi, err := fs.Stat(arg)
+	return err == nil && fi.IsDir()
+}
+
+// splitKeys separates the common and game key files from the end of args.
+// These are the up to two trailing arguments that isArg doesn't accept,
+// following at least one other argument, and each must hold a raw key.
+func splitKeys(args []string, isArg func(string) bool) ([]string, []string, error) {
+	i := len(args)
+	for i > 1 && len(args)-i < 2 && !isArg(args[i-1]) {
+		i--
+	}
+
+	for _, key := range args[i:] {
+		if fi, err := fs.Stat(key); err != nil || !fi.Mode().IsRegular() || fi.Size() != 16 {
+			return nil, nil, fmt.Errorf("invalid key file %s", key)
+		}
+	}
+
+	return args[:i], args[i:], nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bodgit/wud"
+)
+
+// isGamePath returns whether arg is the path of a game file in the image
+// rather than a local key file.
+func isGamePath(arg string) bool {
+	if filepath.Ext(arg) == ".key" {
+		return false
+	}
+	_, err := fs.Stat(arg)
+	return err != nil
+}
+
+func cat(ctx context.Context, name, common, game string, files, parents []string) error {
+	w, c, err := openWUD(name, common, game, parents)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, file := range files {
+		sr, err := w.Open(file)
+		if err != nil {
+			return err
+		}
+
+		if _, err = wud.CopyContext(ctx, os.Stdout, sr, file, sr.Size(), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bodgit/wud"
+	"github.com/schollz/progressbar/v3"
+)
+
+const (
+	hashBufferSize = 1 << 20
+	hashBuffers    = 16
+)
+
+// rom is a Logiqx DAT rom element.
+type rom struct {
+	Name   string `xml:"name,attr"`
+	Size   int64  `xml:"size,attr"`
+	CRC    string `xml:"crc,attr,omitempty"`
+	MD5    string `xml:"md5,attr,omitempty"`
+	SHA1   string `xml:"sha1,attr,omitempty"`
+	SHA256 string `xml:"sha256,attr,omitempty"`
+	Status string `xml:"status,attr,omitempty"`
+}
+
+// game is a Logiqx DAT game element.
+type game struct {
+	XMLName     xml.Name `xml:"game"`
+	Name        string   `xml:"name,attr"`
+	Description string   `xml:"description"`
+	ROMs        []rom    `xml:"rom"`
+}
+
+type hashChunk struct {
+	b    []byte
+	refs int32
+}
+
+// hashImage reads r until EOF, calculating the CRC32, MD5, SHA-1 & SHA-256
+// digests concurrently.
+func hashImage(r io.Reader) (rom, error) {
+	hashes := []hash.Hash{crc32.NewIEEE(), md5.New(), sha1.New(), sha256.New()}
+
+	free := make(chan []byte, hashBuffers)
+	for i := 0; i < hashBuffers; i++ {
+		free <- make([]byte, hashBufferSize)
+	}
+
+	var wg sync.WaitGroup
+	chunks := make([]chan *hashChunk, len(hashes))
+	for i, h := range hashes {
+		chunks[i] = make(chan *hashChunk, hashBuffers)
+		wg.Add(1)
+		go func(h hash.Hash, c <-chan *hashChunk) {
+			defer wg.Done()
+			for chunk := range c {
+				_, _ = h.Write(chunk.b)
+				// Last hash to finish with the buffer frees it
+				if atomic.AddInt32(&chunk.refs, -1) == 0 {
+					free <- chunk.b[:cap(chunk.b)]
+				}
+			}
+		}(h, chunks[i])
+	}
+
+	var size int64
+	var err error
+	for {
+		b := <-free
+		var n int
+		n, err = io.ReadFull(r, b)
+		if n > 0 {
+			size += int64(n)
+			chunk := &hashChunk{b: b[:n], refs: int32(len(hashes))}
+			for _, c := range chunks {
+				c <- chunk
+			}
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			break
+		}
+	}
+
+	for _, c := range chunks {
+		close(c)
+	}
+	wg.Wait()
+
+	if err != nil {
+		return rom{}, err
+	}
+
+	return rom{
+		Size:   size,
+		CRC:    hex.EncodeToString(hashes[0].Sum(nil)),
+		MD5:    hex.EncodeToString(hashes[1].Sum(nil)),
+		SHA1:   hex.EncodeToString(hashes[2].Sum(nil)),
+		SHA256: hex.EncodeToString(hashes[3].Sum(nil)),
+	}, nil
+}
+
+func hashFile(name string, parents []string, verbose bool) (*game, error) {
+	rc, err := openFile(name, parents...)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return hashReader(rc, name, verbose)
+}
+
+func hashReader(rc wud.Reader, name string, verbose bool) (*game, error) {
+	// Hash a trimmed image as if it was full size
+	rc, err := wud.PadImage(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := wud.ReadProductCode(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = io.NewSectionReader(rc, 0, rc.Size())
+
+	if verbose {
+		pb := progressbar.DefaultBytes(rc.Size(), imageName(name))
+		r = io.TeeReader(r, pb)
+	}
+
+	entry, err := hashImage(r)
+	if err != nil {
+		return nil, err
+	}
+	entry.Name = imageName(name) + wud.Extension
+
+	return &game{
+		Name:        code,
+		Description: code,
+		ROMs:        []rom{entry},
+	}, nil
+}
+
+func hashFiles(files []string, parents []string, verbose bool) error {
+	e := xml.NewEncoder(os.Stdout)
+	e.Indent("", "\t")
+
+	for _, file := range files {
+		g, err := hashFile(file, parents, verbose)
+		if err != nil {
+			return err
+		}
+		if err = e.Encode(g); err != nil {
+			return err
+		}
+	}
+
+	_, err := os.Stdout.WriteString("\n")
+
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bodgit/wud"
+)
+
+func printLocalised(w io.Writer, label string, m map[wud.Language]string) {
+	for _, lang := range wud.Languages {
+		if s, ok := m[lang]; ok {
+			fmt.Fprintf(w, "%s (%s):\t%q\n", label, lang, s)
+		}
+	}
+}
+
+func info(name, common, game string, parents []string) error {
+	w, c, err := openWUD(name, common, game, parents)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	meta, err := w.Meta()
+	if err != nil {
+		return err
+	}
+
+	app, err := w.App()
+	if err != nil {
+		return err
+	}
+
+	cos, err := w.Cos()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintf(tw, "Product code:\t%s\n", meta.ProductCode)
+	fmt.Fprintf(tw, "Company code:\t%s\n", meta.CompanyCode)
+	fmt.Fprintf(tw, "Title ID:\t%016X\n", app.TitleID)
+	fmt.Fprintf(tw, "Title version:\t%d\n", app.TitleVersion)
+	fmt.Fprintf(tw, "Group ID:\t%08X\n", app.GroupID)
+	fmt.Fprintf(tw, "Region:\t%s\n", meta.Region)
+	fmt.Fprintf(tw, "OS version:\t%016X\n", app.OSVersion)
+	fmt.Fprintf(tw, "SDK version:\t%d\n", app.SDKVersion)
+	fmt.Fprintf(tw, "Mastering date:\t%s\n", meta.MasteringDate)
+	printLocalised(tw, "Long name", meta.LongNames)
+	printLocalised(tw, "Short name", meta.ShortNames)
+	printLocalised(tw, "Publisher", meta.Publishers)
+	for _, p := range cos.Permissions {
+		fmt.Fprintf(tw, "Permission (%s):\tgroup %d, mask %016X\n", p.Name, p.Group, p.Mask)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bodgit/wud"
+)
+
+// keycheck checks the keys for each image and prints the result, an error is
+// returned if any of them are wrong.
+func keycheck(files, keys []string, parents []string) error {
+	var bad int
+	for _, file := range files {
+		common, game := keyFiles(file, keys)
+
+		err := checkImageKeys(file, common, game, parents)
+		switch {
+		case err == nil:
+			fmt.Printf("OK\t%s\n", file)
+		case errors.Is(err, wud.ErrWrongDiscKey):
+			fmt.Printf("BAD\t%s: wrong disc key\n", file)
+		case errors.Is(err, wud.ErrWrongCommonKey):
+			fmt.Printf("BAD\t%s: wrong common key\n", file)
+		default:
+			fmt.Printf("BAD\t%s: %v\n", file, err)
+		}
+		if err != nil {
+			bad++
+		}
+	}
+
+	if bad > 0 {
+		return fmt.Errorf("%d of %d images failed", bad, len(files))
+	}
+
+	return nil
+}
+
+func checkImageKeys(name, common, game string, parents []string) error {
+	rc, err := openFile(name, parents...)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	commonKey, err := readCommonKey(common)
+	if err != nil {
+		return err
+	}
+
+	gameKey, err := readGameKey(rc, game)
+	if err != nil {
+		return err
+	}
+
+	return wud.CheckKeys(rc, commonKey, gameKey)
+}
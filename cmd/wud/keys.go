@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/bodgit/wud"
+	"github.com/spf13/afero"
+	"github.com/urfave/cli/v2"
+)
+
+// keySettings holds the keys set with the global flags. The key database is
+// only read the first time a key is looked up.
+var keySettings struct {
+	common []byte
+	keys   string
+	once   sync.Once
+	db     *wud.KeyDB
+	err    error
+}
+
+func loadKeySettings(c *cli.Context) error {
+	if s := c.String("common-key"); s != "" {
+		key, err := wud.ParseKey(s)
+		if err != nil {
+			return err
+		}
+		keySettings.common = key
+	} else {
+		key, err := wud.CommonKeyFromEnv()
+		if err != nil {
+			return fmt.Errorf("%s: %w", wud.CommonKeyEnv, err)
+		}
+		keySettings.common = key
+	}
+
+	keySettings.keys = c.Path("keys")
+
+	return nil
+}
+
+// keyDB returns the key database, either the keys.txt file set with the
+// global flags or the key store.
+func keyDB() (*wud.KeyDB, error) {
+	keySettings.once.Do(func() {
+		// Without a keys.txt file, fall back to the key store
+		if keySettings.keys == "" {
+			keySettings.db, keySettings.err = skipBadLines(loadKeyStore())
+			return
+		}
+		keySettings.db, keySettings.err = skipBadLines(readKeyDB(keySettings.keys))
+	})
+	return keySettings.db, keySettings.err
+}
+
+// readKeyDB reads the keys.txt file name. Any bad lines are reported with a
+// *wud.KeyDBError along with the keys from the other lines.
+func readKeyDB(name string) (*wud.KeyDB, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db, err := wud.ReadKeyDB(f)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	}
+
+	return db, err
+}
+
+// skipBadLines prints a warning for any bad lines in a keys.txt file rather
+// than failing so the rest of the keys can still be used.
+func skipBadLines(db *wud.KeyDB, err error) (*wud.KeyDB, error) {
+	var kerr *wud.KeyDBError
+	if errors.As(err, &kerr) {
+		fmt.Fprintf(os.Stderr, "skipping %v\n", err)
+		return db, nil
+	}
+	return db, err
+}
+
+// readCommonKey returns the common key, either that set with the global flags,
+// read from file, or from the key database if file doesn't exist.
+func readCommonKey(file string) ([]byte, error) {
+	if keySettings.common != nil {
+		return keySettings.common, nil
+	}
+
+	b, err := afero.ReadFile(fs, file)
+	if err != nil && os.IsNotExist(err) {
+		db, dberr := keyDB()
+		if dberr != nil {
+			return nil, dberr
+		}
+		if db.Common != nil {
+			return db.Common, nil
+		}
+	}
+
+	return b, err
+}
+
+// readGameKey returns the disc key for the image read from r, either read
+// from file or if that doesn't exist, looked up in the key database using the
+// product code of the image.
+func readGameKey(r io.ReaderAt, file string) ([]byte, error) {
+	b, err := afero.ReadFile(fs, file)
+	if err != nil && os.IsNotExist(err) {
+		db, dberr := keyDB()
+		if dberr != nil {
+			return nil, dberr
+		}
+		code, cerr := wud.ReadProductCode(r)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if key, ok := db.DiscKey(code); ok {
+			return key, nil
+		}
+	}
+
+	return b, err
+}
+
+const keyStoreFile = "keys.txt"
+
+// keyStorePath returns the path of the key store in the user configuration
+// directory.
+func keyStorePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wud", keyStoreFile), nil
+}
+
+// loadKeyStore reads the key store, which is empty if it doesn't exist yet.
+// Any bad lines are reported with a *wud.KeyDBError so that they aren't lost
+// by saving the key store again.
+func loadKeyStore() (*wud.KeyDB, error) {
+	name, err := keyStorePath()
+	if err != nil {
+		// No configuration directory so there can't be a key store
+		return wud.NewKeyDB(), nil
+	}
+
+	db, err := readKeyDB(name)
+	if os.IsNotExist(err) {
+		return wud.NewKeyDB(), nil
+	}
+
+	return db, err
+}
+
+func saveKeyStore(db *wud.KeyDB) error {
+	name, err := keyStorePath()
+	if err != nil {
+		return err
+	}
+
+	if err = fs.MkdirAll(filepath.Dir(name), os.ModePerm|os.ModeDir); err != nil {
+		return err
+	}
+
+	b := new(bytes.Buffer)
+	if _, err = db.WriteTo(b); err != nil {
+		return err
+	}
+
+	partial := name + wud.PartialExtension
+	err = afero.WriteFile(fs, partial, b.Bytes(), 0600)
+
+	return finishTarget(partial, name, false, err)
+}
+
+// keyArg returns the key in s, either a file containing the raw key or a
+// hexadecimal string.
+func keyArg(s string) ([]byte, error) {
+	if b, err := afero.ReadFile(fs, s); err == nil {
+		if len(b) != 16 {
+			return nil, fmt.Errorf("%s is not a key file", s)
+		}
+		return b, nil
+	}
+	return wud.ParseKey(s)
+}
+
+// productCodeArg returns the product code in s, either read from the image
+// with that name or s itself.
+func productCodeArg(s string) (string, error) {
+	if _, err := fs.Stat(s); err != nil {
+		code := strings.ToUpper(s)
+		if !wud.IsProductCode(code) {
+			return "", fmt.Errorf("%s is neither a product code nor an image", s)
+		}
+		return code, nil
+	}
+
+	rc, err := openFile(s)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	return wud.ReadProductCode(rc)
+}
+
+// titleIDArg returns the title ID in s if it is 16 hexadecimal digits.
+func titleIDArg(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	tid, err := strconv.ParseUint(s, 16, 64)
+	return tid, err == nil
+}
+
+func keysAdd(args []string, common bool) error {
+	db, err := loadKeyStore()
+	if err != nil {
+		return err
+	}
+
+	if common {
+		if db.Common, err = keyArg(args[0]); err != nil {
+			return err
+		}
+		return saveKeyStore(db)
+	}
+
+	if len(args) < 2 {
+		return errors.New("a product code or title ID and key are required")
+	}
+
+	key, err := keyArg(args[1])
+	if err != nil {
+		return err
+	}
+
+	other := wud.NewKeyDB()
+
+	if tid, ok := titleIDArg(args[0]); ok {
+		other.Title[tid] = key
+		db.Merge(other)
+		return saveKeyStore(db)
+	}
+
+	code, err := productCodeArg(args[0])
+	if err != nil {
+		return err
+	}
+
+	other.Disc[code] = key
+	if comment := strings.Join(args[2:], " "); comment != "" {
+		other.Comments[code] = comment
+	}
+	db.Merge(other)
+
+	return saveKeyStore(db)
+}
+
+func keysList() error {
+	db, err := skipBadLines(loadKeyStore())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "PRODUCT CODE OR TITLE ID\tKEY\tCOMMENT\n")
+	if db.Common != nil {
+		fmt.Fprintf(tw, "common\t%x\t\n", db.Common)
+	}
+
+	codes := make([]string, 0, len(db.Disc))
+	for code := range db.Disc {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		fmt.Fprintf(tw, "%s\t%x\t%s\n", code, db.Disc[code], db.Comments[code])
+	}
+
+	tids := make([]uint64, 0, len(db.Title))
+	for tid := range db.Title {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+
+	for _, tid := range tids {
+		fmt.Fprintf(tw, "%016x\t%x\t\n", tid, db.Title[tid])
+	}
+	for _, key := range db.Unlabelled {
+		fmt.Fprintf(tw, "\t%x\t\n", key)
+	}
+
+	return tw.Flush()
+}
+
+func keysRemove(codes []string, common bool) error {
+	db, err := loadKeyStore()
+	if err != nil {
+		return err
+	}
+
+	if common {
+		db.Common = nil
+	}
+
+	for _, code := range codes {
+		if tid, ok := titleIDArg(code); ok {
+			if _, ok = db.Title[tid]; !ok {
+				return fmt.Errorf("no key for %s", code)
+			}
+			delete(db.Title, tid)
+			continue
+		}
+
+		code = strings.ToUpper(code)
+		if _, ok := db.Disc[code]; !ok {
+			return fmt.Errorf("no key for %s", code)
+		}
+		delete(db.Disc, code)
+		delete(db.Comments, code)
+	}
+
+	return saveKeyStore(db)
+}
+
+// importKeyFile returns the key in a wudump key file. A common key file
+// populates the common key otherwise the key is added for the product code and
+// title ID of the image alongside it that it decrypts.
+func importKeyFile(name string) (*wud.KeyDB, error) {
+	key, err := keyArg(name)
+	if err != nil {
+		return nil, err
+	}
+
+	db := wud.NewKeyDB()
+
+	if filepath.Base(name) == wud.CommonKeyFile {
+		db.Common = key
+		return db, nil
+	}
+
+	files, err := afero.ReadDir(fs, filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var images int
+	for _, fi := range files {
+		if fi.IsDir() || !isImage(fi.Name()) {
+			continue
+		}
+		images++
+
+		code, tid, err := identifyImage(filepath.Join(filepath.Dir(name), fi.Name()), key)
+		switch {
+		case errors.Is(err, wud.ErrWrongDiscKey), errors.Is(err, wud.ErrBadTOCChecksum):
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		db.Disc[code] = key
+		if tid != 0 {
+			db.Title[tid] = key
+		}
+
+		return db, nil
+	}
+
+	if images == 0 {
+		return nil, fmt.Errorf("no image alongside %s", name)
+	}
+
+	return nil, fmt.Errorf("%s doesn't decrypt any image alongside it", name)
+}
+
+// identifyImage checks key is the disc key for image and returns the product
+// code and, if it can be found, the title ID of the game.
+func identifyImage(image string, key []byte) (string, uint64, error) {
+	rc, err := openFile(image)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+
+	if err = wud.CheckDiscKey(rc, key); err != nil {
+		return "", 0, err
+	}
+
+	w, err := wud.NewWUD(rc, nil, key)
+	if err != nil {
+		return "", 0, err
+	}
+
+	titles, err := w.Titles()
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, t := range titles {
+		for _, tid := range []uint64{t.TitleID, t.TicketTitleID} {
+			if tid>>32 == 0x50000 {
+				return w.ProductCode(), tid, nil
+			}
+		}
+	}
+
+	return w.ProductCode(), 0, nil
+}
+
+func keysImport(files []string) error {
+	db, err := loadKeyStore()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		var other *wud.KeyDB
+
+		if filepath.Ext(file) == ".key" {
+			other, err = importKeyFile(file)
+		} else {
+			other, err = skipBadLines(readKeyDB(file))
+		}
+		if err != nil {
+			return err
+		}
+
+		db.Merge(other)
+	}
+
+	return saveKeyStore(db)
+}
+
+// candidateKeys returns every key in the .key files and keys.txt files in
+// names along with those already known, without duplicates.
+func candidateKeys(names []string) ([][]byte, error) {
+	db, err := keyDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	for _, key := range db.Disc {
+		keys = append(keys, key)
+	}
+	for _, key := range db.Title {
+		keys = append(keys, key)
+	}
+	keys = append(keys, db.Unlabelled...)
+
+	for _, name := range names {
+		if filepath.Ext(name) == ".key" {
+			key, err := keyArg(name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			continue
+		}
+
+		other, err := skipBadLines(readKeyDB(name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range other.Disc {
+			keys = append(keys, key)
+		}
+		for _, key := range other.Title {
+			keys = append(keys, key)
+		}
+		keys = append(keys, other.Unlabelled...)
+	}
+
+	seen := make(map[string]bool)
+	unique := keys[:0]
+	for _, key := range keys {
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			unique = append(unique, key)
+		}
+	}
+
+	return unique, nil
+}
+
+// keysMatch tries each candidate key against each image and prints the
+// matching pairs in the keys.txt format. If add is set they are also added to
+// the key store and if write is set, a game.key file is written alongside
+// each image that doesn't already have one.
+func keysMatch(images, names, parents []string, add, write bool) error {
+	keys, err := candidateKeys(names)
+	if err != nil {
+		return err
+	}
+
+	found := wud.NewKeyDB()
+
+	var unmatched int
+	for _, image := range images {
+		key, code, err := matchDiscKey(image, keys, parents)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			fmt.Fprintf(os.Stderr, "no key for %s\n", image)
+			unmatched++
+			continue
+		}
+
+		found.Disc[code] = key
+		found.Comments[code] = filepath.Base(image)
+
+		if write {
+			target := filepath.Join(filepath.Dir(image), wud.GameKeyFile)
+			switch err := checkTarget(target, clobberSkip); {
+			case errors.Is(err, errSkipped):
+			case err != nil:
+				return err
+			default:
+				partial := target + wud.PartialExtension
+				err = afero.WriteFile(fs, partial, key, 0666)
+				if err = finishTarget(partial, target, false, err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if _, err = found.WriteTo(os.Stdout); err != nil {
+		return err
+	}
+
+	if add {
+		db, err := loadKeyStore()
+		if err != nil {
+			return err
+		}
+		db.Merge(found)
+		if err = saveKeyStore(db); err != nil {
+			return err
+		}
+	}
+
+	if unmatched > 0 {
+		return fmt.Errorf("%d of %d images have no matching key", unmatched, len(images))
+	}
+
+	return nil
+}
+
+// matchDiscKey returns the first of keys that decrypts the table of contents
+// of image along with the product code of the image.
+func matchDiscKey(image string, keys [][]byte, parents []string) ([]byte, string, error) {
+	rc, err := openFile(image, parents...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rc.Close()
+
+	code, err := wud.ReadProductCode(rc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, key := range keys {
+		switch err := wud.CheckDiscKey(rc, key); {
+		case err == nil:
+			return key, code, nil
+		case errors.Is(err, wud.ErrWrongDiscKey), errors.Is(err, wud.ErrBadTOCChecksum):
+		default:
+			return nil, "", err
+		}
+	}
+
+	return nil, code, nil
+}
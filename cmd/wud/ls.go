@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bodgit/wud"
+	"github.com/hashicorp/go-multierror"
+)
+
+type listing struct {
+	Partitions []listPartition `json:"partitions"`
+	Contents   []listContent   `json:"contents"`
+	Files      []listFile      `json:"files,omitempty"`
+}
+
+type listPartition struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+}
+
+type listContent struct {
+	ID     string `json:"id"`
+	Index  uint16 `json:"index"`
+	Type   uint16 `json:"type"`
+	Hashed bool   `json:"hashed"`
+	Size   uint64 `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type listFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Content string `json:"content"`
+}
+
+// openWUDWithoutCommon is like openWUD but if the common key file doesn't
+// exist the image is opened without it.
+func openWUDWithoutCommon(name, common, game string, parents []string) (*wud.WUD, io.Closer, error) {
+	rc, err := openFile(name, parents...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commonKey, err := readCommonKey(common)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, multierror.Append(err, rc.Close())
+	}
+
+	gameKey, err := readGameKey(rc, game)
+	if err != nil {
+		return nil, nil, multierror.Append(err, rc.Close())
+	}
+
+	w, err := wud.NewWUD(rc, commonKey, gameKey)
+	if err != nil {
+		return nil, nil, multierror.Append(err, rc.Close())
+	}
+
+	return w, rc, nil
+}
+
+func newListing(w *wud.WUD) (*listing, error) {
+	l := new(listing)
+
+	for _, p := range w.Partitions() {
+		l.Partitions = append(l.Partitions, listPartition{p.Name, p.Offset})
+	}
+
+	contents, err := w.Contents()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range contents {
+		l.Contents = append(l.Contents, listContent{fmt.Sprintf("%08x", c.ID), c.Index, c.Type, c.Hashed(), c.Size, hex.EncodeToString(c.SHA2[:])})
+	}
+
+	files, err := w.Files()
+	if err != nil {
+		if errors.Is(err, wud.ErrNoCommonKey) {
+			return l, nil
+		}
+		return nil, err
+	}
+	for _, f := range files {
+		l.Files = append(l.Files, listFile{f.Path, f.Size, fmt.Sprintf("%08x", f.ContentID)})
+	}
+
+	// Sort by each path component so directories are kept together
+	sort.SliceStable(l.Files, func(i, j int) bool {
+		a, b := strings.Split(l.Files[i].Path, "/"), strings.Split(l.Files[j].Path, "/")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return l, nil
+}
+
+func printTree(w io.Writer, files []listFile, long bool) {
+	var last []string
+	for _, f := range files {
+		parts := strings.Split(f.Path, "/")
+
+		// Skip the directories shared with the previous file
+		i := 0
+		for i < len(last)-1 && i < len(parts)-1 && last[i] == parts[i] {
+			i++
+		}
+		for ; i < len(parts)-1; i++ {
+			fmt.Fprintf(w, "%s%s/\n", strings.Repeat("  ", i), parts[i])
+		}
+
+		if long {
+			fmt.Fprintf(w, "%s%s\t%d\n", strings.Repeat("  ", i), parts[i], f.Size)
+		} else {
+			fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", i), parts[i])
+		}
+
+		last = parts
+	}
+}
+
+func list(name, common, game string, parents []string, long, asJSON, tree bool) error {
+	w, c, err := openWUDWithoutCommon(name, common, game, parents)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	l, err := newListing(w)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(l)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "PARTITION\tOFFSET\n")
+	for _, p := range l.Partitions {
+		fmt.Fprintf(tw, "%s\t%#x\n", p.Name, p.Offset)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "CONTENT\tINDEX\tTYPE\tSIZE\tSHA-256\n")
+	for _, c := range l.Contents {
+		typ := fmt.Sprintf("%#04x", c.Type)
+		if c.Hashed {
+			typ += " (hashed)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", c.ID, c.Index, typ, c.Size, c.SHA256)
+	}
+
+	if l.Files == nil {
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw)
+
+	switch {
+	case tree:
+		printTree(tw, l.Files, long)
+	case long:
+		fmt.Fprintf(tw, "PATH\tSIZE\tCONTENT\n")
+		for _, f := range l.Files {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", f.Path, f.Size, f.Content)
+		}
+	default:
+		for _, f := range l.Files {
+			fmt.Fprintln(tw, f.Path)
+		}
+	}
+
+	return tw.Flush()
+}
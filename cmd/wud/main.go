@@ -1,21 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/bodgit/plumbing"
 	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/remote"
 	"github.com/bodgit/wud/wux"
 	"github.com/hashicorp/go-multierror"
-	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/afero"
 	"github.com/urfave/cli/v2"
+	"go4.org/readerutil"
 )
 
 var (
@@ -34,7 +38,19 @@ func init() {
 	}
 }
 
-func compress(src, dst string, verbose bool) error {
+// indexParent reads the parent image once so that it can be shared by every
+// image compressed against it.
+func indexParent(parents []string) (*wux.ParentIndex, error) {
+	rc, err := openFile(parents[0], parents[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return wux.NewParentIndex(rc, wud.SectorSize)
+}
+
+func compress(ctx context.Context, src, dst string, parent *wux.ParentIndex, mode clobber, resume, verbose bool) error {
 	if dst == "" {
 		if ext := filepath.Ext(src); ext == wux.Extension {
 			return fmt.Errorf("source file %s already has %s extension", src, wux.Extension)
@@ -43,120 +59,255 @@ func compress(src, dst string, verbose bool) error {
 		dst = strings.TrimSuffix(src, wud.Extension) + wux.Extension
 	}
 
-	rc, err := wud.OpenReader(src)
+	if err := checkTarget(dst, mode); err != nil {
+		return err
+	}
+
+	in, err := wud.OpenReader(src)
 	if err != nil {
 		return err
 	}
-	defer rc.Close()
+	defer in.Close()
 
-	if rc.Size() != int64(wud.UncompressedSize) {
-		return fmt.Errorf("%s file is not %d bytes", wud.Extension, wud.UncompressedSize)
+	rc, err := wud.PadImage(in)
+	if err != nil {
+		return err
 	}
 
-	var r io.Reader = rc
+	partial := dst + wud.PartialExtension
 
-	if verbose {
-		pb := progressbar.DefaultBytes(rc.Size())
-		r = io.TeeReader(r, pb)
-	}
+	var (
+		f   afero.File
+		w   io.WriteCloser
+		off int64
+	)
 
-	f, err := fs.Create(dst)
-	if err != nil {
-		return err
+	if _, err = fs.Stat(partial); resume && err == nil {
+		if f, err = fs.OpenFile(partial, os.O_RDWR, 0); err != nil {
+			return err
+		}
+
+		w, off, err = wux.NewResumeWriter(f, parent)
+	} else {
+		if f, err = fs.Create(partial); err != nil {
+			return err
+		}
+
+		if parent != nil {
+			w, err = wux.NewChildWriter(f, parent, wud.SectorSize, uint64(rc.Size()))
+		} else {
+			w, err = wux.NewWriter(f, wud.SectorSize, uint64(rc.Size()))
+		}
 	}
-	defer f.Close()
 
-	w, err := wux.NewWriter(f, wud.SectorSize, wud.UncompressedSize)
-	if err != nil {
-		return err
+	if err == nil {
+		var p wud.Progress
+		if verbose {
+			p = newProgress(off)
+		}
+
+		if _, err = wud.CopyContext(ctx, w, io.NewSectionReader(rc, off, rc.Size()-off), "", rc.Size(), p); err == nil {
+			err = w.Close()
+		}
 	}
-	defer w.Close()
 
-	_, err = io.Copy(w, r)
+	if cerr := f.Close(); cerr != nil {
+		err = multierror.Append(err, cerr)
+	}
 
-	return err
+	return finishTarget(partial, dst, resume, err)
 }
 
-func decompress(src, dst string, verbose bool) error {
+func decompress(ctx context.Context, src, dst string, parents []string, mode clobber, resume, verbose bool) error {
 	if dst == "" {
 		if ext := filepath.Ext(src); ext == wud.Extension {
 			return fmt.Errorf("source file %s already has %s extension", src, wud.Extension)
 		}
 
 		dst = strings.TrimSuffix(src, wux.Extension) + wud.Extension
+		if isURL(src) {
+			dst = path.Base(dst)
+		}
 	}
 
-	f, err := fs.Open(src)
-	if err != nil {
+	if err := checkTarget(dst, mode); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	r, err := wux.NewReader(f)
+	r, err := openCompressedFile(src, parents...)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
+
+	partial := dst + wud.PartialExtension
 
-	var w io.WriteCloser
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flag &^= os.O_TRUNC
+	}
 
-	w, err = fs.Create(dst)
+	f, err := fs.OpenFile(partial, flag, 0666)
 	if err != nil {
 		return err
 	}
 
-	if verbose {
-		pb := progressbar.DefaultBytes(r.Size())
-		w = plumbing.MultiWriteCloser(w, plumbing.NopWriteCloser(pb))
+	// Continue from the last whole sector that was written
+	var off int64
+	if resume {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			off = fi.Size() - fi.Size()%int64(wud.SectorSize)
+			if off > r.Size() {
+				off = r.Size()
+			}
+
+			if err = f.Truncate(off); err == nil {
+				_, err = f.Seek(off, io.SeekStart)
+			}
+		}
 	}
 
-	defer w.Close()
+	if err == nil {
+		var p wud.Progress
+		if verbose {
+			p = newProgress(off)
+		}
 
-	_, err = io.Copy(w, r)
+		_, err = wud.CopyContext(ctx, f, io.NewSectionReader(r, off, r.Size()-off), "", r.Size(), p)
+	}
 
-	return err
+	if cerr := f.Close(); cerr != nil {
+		err = multierror.Append(err, cerr)
+	}
+
+	return finishTarget(partial, dst, resume, err)
 }
 
-func openFile(name string) (wud.ReadCloser, error) {
-	f, err := fs.Open(name)
+// openCompressedFile opens name as a compressed image. If the image
+// references a parent image then the first of parents is opened as the parent,
+// with any remaining parents used in turn if that is also a child image.
+func openCompressedFile(name string, parents ...string) (wud.ReadCloser, error) {
+	var f readerutil.ReaderAtCloser
+	var err error
+	if isURL(name) {
+		f, err = remote.NewReader(nil, name)
+	} else {
+		f, err = fs.Open(name)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if rc, err := wux.NewReadCloser(f); err != nil {
-		if err != wux.ErrBadMagic {
-			return nil, multierror.Append(err, f.Close())
-		}
-		if err = f.Close(); err != nil {
+	rc, err := wux.NewReadCloser(f)
+	if err != wux.ErrParentRequired || len(parents) == 0 {
+		if err != nil {
+			if e := f.Close(); e != nil {
+				err = multierror.Append(err, e)
+			}
 			return nil, err
 		}
-	} else {
 		return rc, nil
 	}
 
-	return wud.OpenReader(name)
+	parent, err := openFile(parents[0], parents[1:]...)
+	if err != nil {
+		return nil, multierror.Append(err, f.Close())
+	}
+
+	if rc, err = wux.NewChildReadCloser(f, parent); err != nil {
+		return nil, multierror.Append(err, f.Close(), parent.Close())
+	}
+
+	return rc, nil
+}
+
+// isURL returns whether name is an HTTP or HTTPS URL rather than a local
+// file.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
 }
 
-func extract(name, common, game, directory string) error {
-	rc, err := openFile(name)
+func openFile(name string, parents ...string) (wud.ReadCloser, error) {
+	rc, err := openCompressedFile(name, parents...)
 	if err != nil {
-		return err
+		if !errors.Is(err, wux.ErrBadMagic) {
+			return nil, err
+		}
+		if isURL(name) {
+			return remote.NewReader(nil, name)
+		}
+		return wud.OpenReader(name)
+	}
+
+	return rc, nil
+}
+
+// keyFiles returns the common and game key files to use for image, either
+// those in keys or the standard files alongside the image.
+func keyFiles(image string, keys []string) (string, string) {
+	var common, game string
+	if len(keys) > 0 {
+		common = keys[0]
+	}
+	if len(keys) > 1 {
+		game = keys[1]
+	}
+
+	if common == "" {
+		common = filepath.Join(filepath.Dir(image), wud.CommonKeyFile)
+	}
+
+	if game == "" {
+		game = filepath.Join(filepath.Dir(common), wud.GameKeyFile)
 	}
-	defer rc.Close()
 
-	commonKey, err := afero.ReadFile(fs, common)
+	return common, game
+}
+
+func openWUD(name, common, game string, parents []string) (*wud.WUD, io.Closer, error) {
+	rc, err := openFile(name, parents...)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	gameKey, err := afero.ReadFile(fs, game)
+	commonKey, err := readCommonKey(common)
 	if err != nil {
-		return err
+		return nil, nil, multierror.Append(err, rc.Close())
+	}
+
+	gameKey, err := readGameKey(rc, game)
+	if err != nil {
+		return nil, nil, multierror.Append(err, rc.Close())
 	}
 
 	w, err := wud.NewWUD(rc, commonKey, gameKey)
+	if err != nil {
+		return nil, nil, multierror.Append(err, rc.Close())
+	}
+
+	return w, rc, nil
+}
+
+// escapeUnderscore replaces every byte of a name that isn't printable ASCII
+// with an underscore.
+func escapeUnderscore(raw []byte) string {
+	b := make([]byte, len(raw))
+	for i, c := range raw {
+		if c <= ' ' || c >= 0x7f || c == '/' || c == '\\' || (c == '.' && i == 0) {
+			c = '_'
+		}
+		b[i] = c
+	}
+	return string(b)
+}
+
+func extract(ctx context.Context, name, common, game, directory string, parents []string, mode clobber, opts wud.ExtractOptions, verbose bool) error {
+	w, c, err := openWUD(name, common, game, parents)
 	if err != nil {
 		return err
 	}
+	defer c.Close()
 
 	if fi, err := fs.Stat(directory); err != nil || !fi.IsDir() {
 		if err != nil {
@@ -165,7 +316,15 @@ func extract(name, common, game, directory string) error {
 		return errors.New("not a directory")
 	}
 
-	if err = w.Extract(directory); err != nil {
+	if err := checkTarget(filepath.Join(directory, w.ProductCode()), mode); err != nil {
+		return err
+	}
+
+	if verbose {
+		opts.Progress = newProgress(0)
+	}
+
+	if err = w.ExtractContext(ctx, directory, &opts); err != nil {
 		return err
 	}
 
@@ -179,92 +338,787 @@ func main() {
 	app.Usage = "Wii U disc image utility"
 	app.Version = fmt.Sprintf("%s, commit %s, built at %s", version, commit, date)
 
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "common-key",
+			Usage: "use the hexadecimal common `KEY` instead of any " + wud.CommonKeyFile + " file, otherwise it is read from $" + wud.CommonKeyEnv + " if set",
+		},
+		&cli.PathFlag{
+			Name:    "keys",
+			Usage:   "look up any missing keys by product code in the keys.txt `FILE`",
+			EnvVars: []string{"WUD_KEYS"},
+		},
+	}
+	app.Before = loadKeySettings
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	app.Commands = []*cli.Command{
+		{
+			Name:        "artwork",
+			Usage:       "Extract the icon, boot images & boot sound from a " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "",
+			ArgsUsage:   "FILE [KEY]...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				common, game := keyFiles(c.Args().First(), c.Args().Tail())
+
+				return artwork(c.Args().First(), common, game, c.Path("directory"), c.StringSlice("parent"), c.Bool("convert"))
+			},
+			Flags: []cli.Flag{
+				&cli.PathFlag{
+					Name:    "directory",
+					Aliases: []string{"d"},
+					Usage:   "extract to `DIRECTORY`",
+					Value:   cwd,
+				},
+				&cli.BoolFlag{
+					Name:    "convert",
+					Aliases: []string{"c"},
+					Usage:   "convert images to PNG and sound to WAV",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "cat",
+			Usage:       "Print decrypted game files from a " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "Any KEY arguments are the common and game key files, otherwise the standard key files alongside the image are used.",
+			ArgsUsage:   "FILE PATH... [KEY]...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 2 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				files, keys, err := splitKeys(c.Args().Tail(), isGamePath)
+				if err != nil {
+					return err
+				}
+				common, game := keyFiles(c.Args().First(), keys)
+
+				return cat(c.Context, c.Args().First(), common, game, files, c.StringSlice("parent"))
+			},
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
 		{
 			Name:        "compress",
 			Usage:       "Compress a " + wud.Extension + " file into a " + wux.Extension + " file",
 			Description: "",
-			ArgsUsage:   "SOURCE [TARGET]",
+			ArgsUsage:   "SOURCE... [TARGET]",
 			Action: func(c *cli.Context) error {
 				if c.NArg() < 1 {
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
 
-				return compress(c.Args().Get(0), c.Args().Get(1), c.Bool("verbose"))
+				args, dst := splitTarget(c.Args().Slice(), wux.Extension)
+
+				mode, err := clobberMode(c)
+				if err != nil {
+					return err
+				}
+
+				files, err := expandSources(args, c.Bool("recursive"), isUncompressed)
+				if err != nil {
+					return err
+				}
+
+				var parent *wux.ParentIndex
+				if parents := c.StringSlice("parent"); len(parents) > 0 {
+					if parent, err = indexParent(parents); err != nil {
+						return err
+					}
+				}
+
+				return runBatch(files, c.Int("jobs"), func(file string) error {
+					return compress(c.Context, file, dst, parent, mode, c.Bool("resume"), c.Bool("verbose") && c.Int("jobs") <= 1)
+				})
 			},
 			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "recursive",
+					Aliases: []string{"r"},
+					Usage:   "find images in any directories recursively",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "process up to `N` images concurrently",
+					Value:   1,
+				},
 				&cli.BoolFlag{
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					Usage:   "increase verbosity",
 				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "deduplicate against `PARENT` image, repeat for each ancestor",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"f"},
+					Usage:   "overwrite any existing TARGET",
+				},
+				&cli.BoolFlag{
+					Name:    "no-clobber",
+					Aliases: []string{"n"},
+					Usage:   "skip any existing TARGET",
+				},
+				&cli.BoolFlag{
+					Name:  "resume",
+					Usage: "continue writing an interrupted TARGET, which is kept if writing fails",
+				},
 			},
 		},
 		{
 			Name:        "decompress",
 			Usage:       "Decompress a " + wux.Extension + " file back to a " + wud.Extension + " file",
 			Description: "",
-			ArgsUsage:   "SOURCE [TARGET]",
+			ArgsUsage:   "SOURCE... [TARGET]",
 			Action: func(c *cli.Context) error {
 				if c.NArg() < 1 {
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
 
-				return decompress(c.Args().Get(0), c.Args().Get(1), c.Bool("verbose"))
+				args, dst := splitTarget(c.Args().Slice(), wud.Extension)
+
+				mode, err := clobberMode(c)
+				if err != nil {
+					return err
+				}
+
+				files, err := expandSources(args, c.Bool("recursive"), isCompressed)
+				if err != nil {
+					return err
+				}
+
+				return runBatch(files, c.Int("jobs"), func(file string) error {
+					return decompress(c.Context, file, dst, c.StringSlice("parent"), mode, c.Bool("resume"), c.Bool("verbose") && c.Int("jobs") <= 1)
+				})
 			},
 			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "recursive",
+					Aliases: []string{"r"},
+					Usage:   "find images in any directories recursively",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "process up to `N` images concurrently",
+					Value:   1,
+				},
 				&cli.BoolFlag{
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					Usage:   "increase verbosity",
 				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"f"},
+					Usage:   "overwrite any existing TARGET",
+				},
+				&cli.BoolFlag{
+					Name:    "no-clobber",
+					Aliases: []string{"n"},
+					Usage:   "skip any existing TARGET",
+				},
+				&cli.BoolFlag{
+					Name:  "resume",
+					Usage: "continue writing an interrupted TARGET, which is kept if writing fails",
+				},
 			},
 		},
 		{
 			Name:        "extract",
 			Usage:       "Extract .cert, .tik, .tmd & .app files from a " + wud.Extension + " or " + wux.Extension + " file",
-			Description: "",
-			ArgsUsage:   "FILE [KEY]...",
+			Description: "Any KEY arguments are the common and game key files, otherwise the standard key files alongside each image are used. If --include or --exclude are used then the matching game files are decrypted and extracted instead.",
+			ArgsUsage:   "FILE... [KEY]...",
 			Action: func(c *cli.Context) error {
 				if c.NArg() < 1 {
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
 
-				file := c.Args().Get(0)
+				args, keys, err := splitKeys(c.Args().Slice(), isSource)
+				if err != nil {
+					return err
+				}
 
-				common := c.Args().Get(1)
-				if common == "" {
-					common = filepath.Join(filepath.Dir(file), wud.CommonKeyFile)
+				mode, err := clobberMode(c)
+				if err != nil {
+					return err
 				}
 
-				game := c.Args().Get(2)
-				if game == "" {
-					game = filepath.Join(filepath.Dir(common), wud.GameKeyFile)
+				opts := wud.ExtractOptions{
+					Include: c.StringSlice("include"),
+					Exclude: c.StringSlice("exclude"),
+				}
+				switch c.String("escape") {
+				case "percent":
+				case "underscore":
+					opts.Escape = escapeUnderscore
+				default:
+					return fmt.Errorf("unknown escape scheme %s", c.String("escape"))
+				}
+				for _, s := range c.StringSlice("content") {
+					id, err := strconv.ParseUint(s, 16, 32)
+					if err != nil {
+						return fmt.Errorf("bad content ID %s", s)
+					}
+					opts.ContentIDs = append(opts.ContentIDs, uint32(id))
 				}
 
-				if err := extract(file, common, game, c.Path("directory")); err != nil {
+				files, err := expandSources(args, c.Bool("recursive"), isImage)
+				if err != nil {
 					return err
 				}
 
-				return nil
+				return runBatch(files, c.Int("jobs"), func(file string) error {
+					common, game := keyFiles(file, keys)
+
+					return extract(c.Context, file, common, game, c.Path("directory"), c.StringSlice("parent"), mode, opts, c.Bool("verbose") && c.Int("jobs") <= 1)
+				})
 			},
 			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "recursive",
+					Aliases: []string{"r"},
+					Usage:   "find images in any directories recursively",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "process up to `N` images concurrently",
+					Value:   1,
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					Usage:   "increase verbosity",
+				},
 				&cli.PathFlag{
 					Name:    "directory",
 					Aliases: []string{"d"},
 					Usage:   "extract to `DIRECTORY`",
 					Value:   cwd,
 				},
+				&cli.StringSliceFlag{
+					Name:  "content",
+					Usage: "only extract the content with hexadecimal `ID`, repeat for each content",
+				},
+				&cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "only extract game files matching `PATTERN`, such as code/*.rpx",
+				},
+				&cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "don't extract game files matching `PATTERN`",
+				},
+				&cli.StringFlag{
+					Name:  "escape",
+					Usage: "escape game file names that can't be decoded using `SCHEME`, either percent or underscore",
+					Value: "percent",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"f"},
+					Usage:   "overwrite any existing files",
+				},
+				&cli.BoolFlag{
+					Name:    "no-clobber",
+					Aliases: []string{"n"},
+					Usage:   "skip any image already extracted",
+				},
+			},
+		},
+		{
+			Name:        "hash",
+			Usage:       "Print a Logiqx DAT entry with the hashes of the uncompressed image",
+			Description: "",
+			ArgsUsage:   "FILE...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				return hashFiles(c.Args().Slice(), c.StringSlice("parent"), c.Bool("verbose"))
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					Usage:   "increase verbosity",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "info",
+			Usage:       "Show the title metadata of a " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "",
+			ArgsUsage:   "FILE [KEY]...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				common, game := keyFiles(c.Args().First(), c.Args().Tail())
+
+				return info(c.Args().First(), common, game, c.StringSlice("parent"))
+			},
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "keycheck",
+			Usage:       "Check the keys can decrypt each " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "Any KEY arguments are the common and game key files, otherwise the standard key files alongside each image are used.",
+			ArgsUsage:   "FILE... [KEY]...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				args, keys, err := splitKeys(c.Args().Slice(), isSource)
+				if err != nil {
+					return err
+				}
+
+				files, err := expandSources(args, c.Bool("recursive"), isImage)
+				if err != nil {
+					return err
+				}
+
+				return keycheck(files, keys, c.StringSlice("parent"))
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "recursive",
+					Aliases: []string{"r"},
+					Usage:   "find images in any directories recursively",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "keys",
+			Usage:       "Manage the key store used to find missing keys",
+			Description: "The key store is a keys.txt file in the user configuration directory that is used unless --keys is passed.",
+			Subcommands: []*cli.Command{
+				{
+					Name:        "add",
+					Usage:       "Add a disc key, or the common key, to the key store",
+					Description: "The disc key is added for either a product code, a 16 digit hexadecimal title ID or the product code read from an IMAGE. The key can be read from a KEYFILE.",
+					ArgsUsage:   "CODE|TITLEID|IMAGE KEY|KEYFILE [COMMENT]...",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						return keysAdd(c.Args().Slice(), c.Bool("common"))
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "common",
+							Usage: "add the common key, the only argument is the KEY|KEYFILE",
+						},
+					},
+				},
+				{
+					Name:        "import",
+					Usage:       "Import keys from keys.txt files or wudump .key files",
+					Description: "A disc key in a .key file is added for the product code and title ID of the image alongside it that it decrypts.",
+					ArgsUsage:   "FILE...",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						return keysImport(c.Args().Slice())
+					},
+				},
+				{
+					Name:        "list",
+					Usage:       "List the keys in the key store",
+					Description: "",
+					Action: func(c *cli.Context) error {
+						return keysList()
+					},
+				},
+				{
+					Name:        "match",
+					Usage:       "Find the disc key for each image by trying every candidate key",
+					Description: "Candidate keys are read from any .key files or keys.txt files as well as the known keys. The matching keys are printed in the keys.txt format.",
+					ArgsUsage:   "FILE... KEYFILE...",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						var args, names []string
+						for _, arg := range c.Args().Slice() {
+							switch filepath.Ext(arg) {
+							case ".key", ".txt":
+								names = append(names, arg)
+							default:
+								args = append(args, arg)
+							}
+						}
+
+						files, err := expandSources(args, c.Bool("recursive"), isImage)
+						if err != nil {
+							return err
+						}
+
+						return keysMatch(files, names, c.StringSlice("parent"), c.Bool("add"), c.Bool("write"))
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "recursive",
+							Aliases: []string{"r"},
+							Usage:   "find images in any directories recursively",
+						},
+						&cli.BoolFlag{
+							Name:  "add",
+							Usage: "add the matching keys to the key store",
+						},
+						&cli.BoolFlag{
+							Name:  "write",
+							Usage: "write a " + wud.GameKeyFile + " file alongside each image that doesn't have one",
+						},
+						&cli.StringSliceFlag{
+							Name:    "parent",
+							Aliases: []string{"p"},
+							Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+						},
+					},
+				},
+				{
+					Name:        "remove",
+					Usage:       "Remove disc keys, or the common key, from the key store",
+					Description: "",
+					ArgsUsage:   "CODE|TITLEID...",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 && !c.Bool("common") {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						return keysRemove(c.Args().Slice(), c.Bool("common"))
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "common",
+							Usage: "remove the common key",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "ls",
+			Usage:       "List the partitions, contents & files in a " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "The files are only listed if the common key is available.",
+			ArgsUsage:   "FILE [KEY]...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				common, game := keyFiles(c.Args().First(), c.Args().Tail())
+
+				return list(c.Args().First(), common, game, c.StringSlice("parent"), c.Bool("long"), c.Bool("json"), c.Bool("tree"))
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "long",
+					Aliases: []string{"l"},
+					Usage:   "include the size of each file",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the listing as JSON",
+				},
+				&cli.BoolFlag{
+					Name:    "tree",
+					Aliases: []string{"t"},
+					Usage:   "print the files as a tree",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "match",
+			Usage:       "Identify images using a Logiqx DAT file and rename them",
+			Description: "Hashes are cached so images are only hashed again if they change.",
+			ArgsUsage:   "DAT [DIRECTORY]",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				directory := c.Args().Get(1)
+				if directory == "" {
+					directory = cwd
+				}
+
+				return match(c.Args().First(), directory, c.Path("move"), c.Bool("dry-run"), c.Bool("verbose"))
+			},
+			Flags: []cli.Flag{
+				&cli.PathFlag{
+					Name:    "move",
+					Aliases: []string{"m"},
+					Usage:   "move identified images to `DIRECTORY`",
+				},
+				&cli.BoolFlag{
+					Name:    "dry-run",
+					Aliases: []string{"n"},
+					Usage:   "report what would be renamed without renaming",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					Usage:   "increase verbosity",
+				},
+			},
+		},
+		{
+			Name:        "nbd",
+			Usage:       "Export images read-only using the Network Block Device protocol",
+			Description: "Each image is exported using its filename without the extension, the first image is also the default export.",
+			ArgsUsage:   "FILE...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				return serveNBD(c.Args().Slice(), c.String("listen"), c.StringSlice("parent"))
+			},
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "listen",
+					Aliases: []string{"l"},
+					Usage:   "listen on `ADDRESS`",
+					Value:   ":10809",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors of child images from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "serve",
+			Usage:       "Serve the images in a directory over HTTP",
+			Description: "Each " + wux.Extension + " file is also served decompressed with a " + wud.Extension + " extension and each split image is served as a single game" + wud.Extension + " file.",
+			ArgsUsage:   "[DIRECTORY]",
+			Action: func(c *cli.Context) error {
+				directory := c.Args().First()
+				if directory == "" {
+					directory = cwd
+				}
+
+				return serve(directory, c.String("listen"), c.StringSlice("parent"))
+			},
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "listen",
+					Aliases: []string{"l"},
+					Usage:   "listen on `ADDRESS`",
+					Value:   ":8080",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors of child images from `PARENT` image, repeat for each ancestor",
+				},
+			},
+		},
+		{
+			Name:        "store",
+			Usage:       "Manage a deduplicated sector store shared by many images",
+			Description: "",
+			Subcommands: []*cli.Command{
+				{
+					Name:        "import",
+					Usage:       "Import " + wud.Extension + " or " + wux.Extension + " files into the store, creating it if necessary",
+					Description: "",
+					ArgsUsage:   "STORE FILE...",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 2 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						return storeImport(c.Args().First(), c.Args().Tail(), c.Bool("verbose"))
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "verbose",
+							Aliases: []string{"v"},
+							Usage:   "increase verbosity",
+						},
+					},
+				},
+				{
+					Name:        "export",
+					Usage:       "Export an image from the store to a " + wud.Extension + " file",
+					Description: "",
+					ArgsUsage:   "STORE NAME [TARGET]",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 2 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						mode, err := clobberMode(c)
+						if err != nil {
+							return err
+						}
+
+						return storeExport(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), mode, c.Bool("verbose"))
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "verbose",
+							Aliases: []string{"v"},
+							Usage:   "increase verbosity",
+						},
+						&cli.BoolFlag{
+							Name:    "force",
+							Aliases: []string{"f"},
+							Usage:   "overwrite any existing TARGET",
+						},
+						&cli.BoolFlag{
+							Name:    "no-clobber",
+							Aliases: []string{"n"},
+							Usage:   "skip any existing TARGET",
+						},
+					},
+				},
+				{
+					Name:        "list",
+					Usage:       "List the images in the store",
+					Description: "",
+					ArgsUsage:   "STORE",
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 {
+							cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+						}
+
+						return storeList(c.Args().First())
+					},
+				},
+			},
+		},
+		{
+			Name:        "trim",
+			Usage:       "Remove the trailing unused space from a " + wud.Extension + " or " + wux.Extension + " file",
+			Description: "The trimmed " + wud.Extension + " file is padded with zeros again when it is read so it can be used like any other image.",
+			ArgsUsage:   "SOURCE... [TARGET]",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+
+				args, dst := splitTarget(c.Args().Slice(), wud.Extension)
+
+				mode, err := clobberMode(c)
+				if err != nil {
+					return err
+				}
+
+				files, err := expandSources(args, c.Bool("recursive"), isImage)
+				if err != nil {
+					return err
+				}
+
+				return runBatch(files, c.Int("jobs"), func(file string) error {
+					return trim(c.Context, file, dst, c.StringSlice("parent"), mode, c.Bool("verbose") && c.Int("jobs") <= 1)
+				})
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "recursive",
+					Aliases: []string{"r"},
+					Usage:   "find images in any directories recursively",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "process up to `N` images concurrently",
+					Value:   1,
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					Usage:   "increase verbosity",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"f"},
+					Usage:   "overwrite any existing TARGET",
+				},
+				&cli.BoolFlag{
+					Name:    "no-clobber",
+					Aliases: []string{"n"},
+					Usage:   "skip any existing TARGET",
+				},
+				&cli.StringSliceFlag{
+					Name:    "parent",
+					Aliases: []string{"p"},
+					Usage:   "read missing sectors from `PARENT` image, repeat for each ancestor",
+				},
 			},
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
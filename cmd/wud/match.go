@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/wux"
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+)
+
+const hashCacheFile = "hashes.json"
+
+type datafile struct {
+	XMLName xml.Name `xml:"datafile"`
+	Games   []game   `xml:"game"`
+}
+
+type cacheEntry struct {
+	Size    int64
+	ModTime time.Time
+	ROM     rom
+}
+
+type hashCache struct {
+	path    string
+	dirty   bool
+	Entries map[string]cacheEntry
+}
+
+func loadDAT(name string) (*datafile, error) {
+	b, err := afero.ReadFile(fs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(datafile)
+	if err = xml.Unmarshal(b, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// lookup finds the game and rom matching r, preferring SHA-1 and falling
+// back to CRC32 and size.
+func (d *datafile) lookup(r rom) (*game, *rom) {
+	for i := range d.Games {
+		g := &d.Games[i]
+		for j := range g.ROMs {
+			x := &g.ROMs[j]
+			if x.SHA1 != "" && strings.EqualFold(x.SHA1, r.SHA1) {
+				return g, x
+			}
+			if x.SHA1 == "" && strings.EqualFold(x.CRC, r.CRC) && x.Size == r.Size {
+				return g, x
+			}
+		}
+	}
+	return nil, nil
+}
+
+func loadHashCache() (*hashCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &hashCache{
+		path:    filepath.Join(dir, "wud", hashCacheFile),
+		Entries: make(map[string]cacheEntry),
+	}
+
+	b, err := afero.ReadFile(fs, c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *hashCache) save() error {
+	if !c.dirty {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err = fs.MkdirAll(filepath.Dir(c.path), os.ModePerm|os.ModeDir); err != nil {
+		return err
+	}
+
+	return afero.WriteFile(fs, c.path, b, 0666)
+}
+
+// isSplit returns whether name is the first part of a split image.
+func isSplit(name string) bool {
+	return filepath.Base(name) == "game_part1"+wud.Extension
+}
+
+// imageStat returns the total size and latest modification time of all of the
+// files that make up the image name.
+func imageStat(name string) (int64, time.Time, error) {
+	files := []string{name}
+	if isSplit(name) {
+		var err error
+		if files, err = afero.Glob(fs, filepath.Join(filepath.Dir(name), "game_part*"+wud.Extension)); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+
+	var size int64
+	var modTime time.Time
+	for _, file := range files {
+		fi, err := fs.Stat(file)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		size += fi.Size()
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+
+	return size, modTime, nil
+}
+
+// hash returns the hashes of the uncompressed image name, using the cached
+// values if the image hasn't changed since.
+func (c *hashCache) hash(name string, verbose bool) (rom, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return rom{}, err
+	}
+
+	size, modTime, err := imageStat(name)
+	if err != nil {
+		return rom{}, err
+	}
+
+	if e, ok := c.Entries[abs]; ok && e.Size == size && e.ModTime.Equal(modTime) {
+		return e.ROM, nil
+	}
+
+	rc, err := openFile(name)
+	if err != nil {
+		return rom{}, err
+	}
+	defer rc.Close()
+
+	g, err := hashReader(rc, name, verbose)
+	if err != nil {
+		return rom{}, err
+	}
+
+	c.Entries[abs] = cacheEntry{
+		Size:    size,
+		ModTime: modTime,
+		ROM:     g.ROMs[0],
+	}
+	c.dirty = true
+
+	return g.ROMs[0], nil
+}
+
+// rename moves the cached hashes for the image or directory of images at src
+// to dst so they are still found after src is renamed.
+func (c *hashCache) rename(src, dst string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if dst, err = filepath.Abs(dst); err != nil {
+		return err
+	}
+
+	for name, e := range c.Entries {
+		if name != src && !strings.HasPrefix(name, src+string(filepath.Separator)) {
+			continue
+		}
+		delete(c.Entries, name)
+		c.Entries[dst+strings.TrimPrefix(name, src)] = e
+		c.dirty = true
+	}
+
+	return nil
+}
+
+// findImages returns every image under directory.
+func findImages(directory string) ([]string, error) {
+	images := []string{}
+	err := afero.Walk(fs, directory, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		switch base := filepath.Base(name); {
+		case filepath.Ext(base) == wux.Extension, isSplit(name):
+			images = append(images, name)
+		case filepath.Ext(base) == wud.Extension && !strings.HasPrefix(base, "game_part"):
+			images = append(images, name)
+		}
+
+		return nil
+	})
+	return images, err
+}
+
+// validName returns an error if name from a DAT file isn't a single path
+// element that can be safely joined to a directory.
+func validName(name string) error {
+	if name == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) || filepath.Clean(name) != name {
+		return fmt.Errorf("bad name %q in DAT file", name)
+	}
+	return nil
+}
+
+// canonicalName returns where the image name should be moved to, given the
+// matching game and rom. A split image is moved by renaming its directory.
+func canonicalName(name, destination string, g *game, r *rom) (string, string, error) {
+	if isSplit(name) {
+		if err := validName(g.Name); err != nil {
+			return "", "", err
+		}
+
+		dir := filepath.Dir(name)
+		if destination == "" {
+			destination = filepath.Dir(dir)
+		}
+		return dir, filepath.Join(destination, g.Name), nil
+	}
+
+	if err := validName(r.Name); err != nil {
+		return "", "", err
+	}
+
+	if destination == "" {
+		destination = filepath.Dir(name)
+	}
+
+	target := r.Name
+	if filepath.Ext(name) == wux.Extension {
+		target = strings.TrimSuffix(target, wud.Extension) + wux.Extension
+	}
+
+	return name, filepath.Join(destination, target), nil
+}
+
+func match(dat, directory, destination string, dryRun, verbose bool) (err error) {
+	d, err := loadDAT(dat)
+	if err != nil {
+		return err
+	}
+
+	c, err := loadHashCache()
+	if err != nil {
+		return err
+	}
+	// Save any new hashes even if a later image fails
+	defer func() {
+		if e := c.save(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}()
+
+	images, err := findImages(directory)
+	if err != nil {
+		return err
+	}
+
+	var unknown, bad int
+	for _, image := range images {
+		r, err := c.hash(image, verbose)
+		if err != nil {
+			if errors.Is(err, wud.ErrWrongSize) {
+				fmt.Printf("BAD\t%s: %v\n", image, err)
+				bad++
+				continue
+			}
+			return err
+		}
+
+		g, x := d.lookup(r)
+		switch {
+		case g == nil:
+			fmt.Printf("UNKNOWN\t%s\n", image)
+			unknown++
+			continue
+		case x.Status == "baddump":
+			fmt.Printf("BAD\t%s: bad dump of %s\n", image, g.Name)
+			bad++
+			continue
+		}
+
+		src, dst, err := canonicalName(image, destination, g, x)
+		if err != nil {
+			return err
+		}
+		if src == dst {
+			fmt.Printf("OK\t%s\n", src)
+			continue
+		}
+
+		if _, err := fs.Stat(dst); err == nil {
+			return fmt.Errorf("%s already exists", dst)
+		}
+
+		fmt.Printf("RENAME\t%s -> %s\n", src, dst)
+
+		if dryRun {
+			continue
+		}
+
+		if err = fs.MkdirAll(filepath.Dir(dst), os.ModePerm|os.ModeDir); err != nil {
+			return err
+		}
+		if err = fs.Rename(src, dst); err != nil {
+			return err
+		}
+		if err = c.rename(src, dst); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d images, %d unknown, %d bad\n", len(images), unknown, bad)
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/nbd"
+	"github.com/hashicorp/go-multierror"
+	"go4.org/readerutil"
+)
+
+func serveNBD(files []string, address string, parents []string) (err error) {
+	s := &nbd.Server{
+		Exports: make(map[string]readerutil.SizeReaderAt),
+	}
+
+	for i, file := range files {
+		rc, oerr := openFile(file, parents...)
+		if oerr != nil {
+			return oerr
+		}
+		defer func() {
+			if e := rc.Close(); e != nil {
+				err = multierror.Append(err, e)
+			}
+		}()
+
+		// Export a trimmed image at its full size
+		r, perr := wud.PadImage(rc)
+		if perr != nil {
+			return perr
+		}
+
+		name := imageName(file)
+		s.Exports[name] = r
+		if i == 0 {
+			// Default export for clients that don't ask for one
+			s.Exports[""] = r
+		}
+		log.Printf("exporting %s as %q", file, name)
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("listening on %s", l.Addr())
+
+	return s.Serve(l)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/bodgit/wud"
+	"github.com/schollz/progressbar/v3"
+)
+
+// newProgress returns a wud.Progress that displays a progress bar. Any bytes
+// already written by an earlier, resumed, attempt are passed as off.
+func newProgress(off int64) wud.Progress {
+	var (
+		pb   *progressbar.ProgressBar
+		last string
+	)
+	return wud.ProgressFunc(func(name string, written, total int64) {
+		if pb == nil {
+			pb = progressbar.DefaultBytes(total)
+		}
+		if name != last {
+			pb.Describe(name)
+			last = name
+		}
+		_ = pb.Set64(off + written)
+	})
+}
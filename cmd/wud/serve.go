@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/wux"
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+)
+
+const splitPart1 = "game_part1" + wud.Extension
+
+// openImage is an image that has been opened for a request. It is kept open
+// for the requests that follow until any of its files change and is only
+// closed once the last request still reading it has released it.
+type openImage struct {
+	rc      wud.ReadCloser
+	size    int64
+	modTime time.Time
+	refs    int
+	evicted bool
+}
+
+type imageServer struct {
+	root    string
+	parents []string
+	mu      sync.Mutex
+	cache   map[string]*openImage
+}
+
+// resolve maps the request path p to a file under the root and whether it
+// should be served raw or via a wud.Reader.
+func (s *imageServer) resolve(p string) (string, bool, error) {
+	name := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p)))
+
+	if fi, err := fs.Stat(name); err == nil && fi.Mode().IsRegular() {
+		switch filepath.Ext(name) {
+		case wud.Extension:
+			return name, false, nil
+		case wux.Extension:
+			return name, true, nil
+		}
+		return "", false, os.ErrNotExist
+	}
+
+	if filepath.Ext(name) != wud.Extension {
+		return "", false, os.ErrNotExist
+	}
+
+	// Decompressed view of a compressed image
+	compressed := strings.TrimSuffix(name, wud.Extension) + wux.Extension
+	if fi, err := fs.Stat(compressed); err == nil && fi.Mode().IsRegular() {
+		return compressed, false, nil
+	}
+
+	// Split image, "game.wud" represents all of the parts
+	if filepath.Base(name) == "game"+wud.Extension {
+		split := filepath.Join(filepath.Dir(name), splitPart1)
+		if fi, err := fs.Stat(split); err == nil && fi.Mode().IsRegular() {
+			return split, false, nil
+		}
+	}
+
+	return "", false, os.ErrNotExist
+}
+
+// open returns the image name, reusing the reader opened by an earlier
+// request if the image hasn't changed since. The image must be released
+// with release once the request has finished with it.
+func (s *imageServer) open(name string) (*openImage, error) {
+	size, modTime, err := imageStat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i, ok := s.cache[name]; ok {
+		if i.size == size && i.modTime.Equal(modTime) {
+			i.refs++
+			return i, nil
+		}
+		delete(s.cache, name)
+		if err = s.evict(i); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}
+
+	rc, err := openFile(name, s.parents...)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &openImage{rc: rc, size: size, modTime: modTime, refs: 1}
+	s.cache[name] = i
+
+	return i, nil
+}
+
+// release drops a reference to i taken by open, closing it if it has been
+// evicted from the cache and nothing else is reading it.
+func (s *imageServer) release(i *openImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i.refs--
+	if i.evicted && i.refs == 0 {
+		return i.rc.Close()
+	}
+
+	return nil
+}
+
+// evict marks i as no longer cached and closes it if nothing is reading it,
+// otherwise the last request to release it closes it. s.mu must be held.
+func (s *imageServer) evict(i *openImage) error {
+	i.evicted = true
+	if i.refs == 0 {
+		return i.rc.Close()
+	}
+	return nil
+}
+
+// close evicts every image opened by the server.
+func (s *imageServer) close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, i := range s.cache {
+		if e := s.evict(i); e != nil {
+			err = multierror.Append(err, e)
+		}
+		delete(s.cache, name)
+	}
+
+	return
+}
+
+func (s *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/" {
+		s.serveIndex(w, r)
+		return
+	}
+
+	name, raw, err := s.resolve(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		rs      io.ReadSeeker
+		size    int64
+		modTime time.Time
+	)
+	if raw {
+		f, err := fs.Open(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rs, size, modTime = f, fi.Size(), fi.ModTime()
+	} else {
+		i, err := s.open(name)
+		switch {
+		case errors.Is(err, wux.ErrParentRequired):
+			http.Error(w, fmt.Sprintf("%s is a child image, its parent must be set with --parent", path.Base(r.URL.Path)), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			if err := s.release(i); err != nil {
+				log.Printf("%s: %v", name, err)
+			}
+		}()
+		// Each request gets its own offset
+		rs, size, modTime = io.NewSectionReader(i.rc, 0, i.rc.Size()), i.size, i.modTime
+	}
+
+	// The tag covers every part of a split image and the view of the
+	// source file is part of it as the same file can be served both raw
+	// and decompressed
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-%t"`, size, modTime.UnixNano(), raw))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	http.ServeContent(w, r, path.Base(r.URL.Path), modTime, rs)
+}
+
+// images returns the request paths of every image found under the root.
+func (s *imageServer) images() ([]string, error) {
+	images := []string{}
+	err := afero.Walk(fs, s.root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case filepath.Ext(rel) == wux.Extension:
+			images = append(images, rel, strings.TrimSuffix(rel, wux.Extension)+wud.Extension)
+		case path.Base(rel) == splitPart1:
+			images = append(images, path.Join(path.Dir(rel), "game"+wud.Extension))
+		case filepath.Ext(rel) == wud.Extension && !strings.HasPrefix(path.Base(rel), "game_part"):
+			images = append(images, rel)
+		}
+
+		return nil
+	})
+	sort.Strings(images)
+
+	return images, err
+}
+
+func (s *imageServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	images, err := s.images()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<pre>")
+	for _, image := range images {
+		u := (&url.URL{Path: "/" + image}).String()
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(u), html.EscapeString(image))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+func serve(directory, address string, parents []string) (err error) {
+	s := &imageServer{
+		root:    directory,
+		parents: parents,
+		cache:   make(map[string]*openImage),
+	}
+	defer func() {
+		if e := s.close(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}()
+
+	log.Printf("serving %s on %s", directory, address)
+
+	return http.ListenAndServe(address, s)
+}
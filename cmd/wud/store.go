@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/wud"
+	"github.com/bodgit/wud/store"
+	"github.com/bodgit/wud/wux"
+	"github.com/hashicorp/go-multierror"
+	"github.com/schollz/progressbar/v3"
+)
+
+// imageName returns a name for the disc image at path, derived from the
+// filename without any extension, or the directory name for a split image.
+func imageName(path string) string {
+	base := filepath.Base(path)
+	if strings.HasPrefix(base, "game_part") && filepath.Ext(base) == wud.Extension {
+		return filepath.Base(filepath.Dir(path))
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(base, wux.Extension), wud.Extension)
+}
+
+func openStore(dir string) (*store.Store, error) {
+	s, err := store.Open(dir)
+	if err != nil && os.IsNotExist(err) {
+		return store.Create(dir, wud.SectorSize)
+	}
+	return s, err
+}
+
+func storeImport(dir string, files []string, verbose bool) error {
+	s, err := openStore(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := func() error {
+			rc, err := openFile(file)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			var r io.Reader = rc
+
+			if verbose {
+				pb := progressbar.DefaultBytes(rc.Size(), imageName(file))
+				r = io.TeeReader(r, pb)
+			}
+
+			return s.Import(imageName(file), r, rc.Size())
+		}(); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+func storeExport(dir, name, dst string, mode clobber, verbose bool) error {
+	s, err := store.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if dst == "" {
+		dst = name + wud.Extension
+	}
+
+	switch err := checkTarget(dst, mode); {
+	case errors.Is(err, errSkipped):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	rc, err := s.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+
+	if verbose {
+		pb := progressbar.DefaultBytes(rc.Size())
+		r = io.TeeReader(r, pb)
+	}
+
+	partial := dst + wud.PartialExtension
+
+	f, err := fs.Create(partial)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+
+	if cerr := f.Close(); cerr != nil {
+		err = multierror.Append(err, cerr)
+	}
+
+	return finishTarget(partial, dst, false, err)
+}
+
+func storeList(dir string) error {
+	s, err := store.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	names, err := s.Manifests()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+type clobber int
+
+const (
+	clobberError clobber = iota // Refuse to overwrite an existing target
+	clobberForce                // Overwrite an existing target
+	clobberSkip                 // Silently skip an existing target
+)
+
+// errSkipped is returned by checkTarget if an existing target is skipped.
+var errSkipped = errors.New("skipped")
+
+// clobberMode returns how existing targets are handled based on the --force
+// and --no-clobber flags.
+func clobberMode(c *cli.Context) (clobber, error) {
+	switch {
+	case c.Bool("force") && c.Bool("no-clobber"):
+		return clobberError, errors.New("--force and --no-clobber are mutually exclusive")
+	case c.Bool("force"):
+		return clobberForce, nil
+	case c.Bool("no-clobber"):
+		return clobberSkip, nil
+	}
+	return clobberError, nil
+}
+
+// checkTarget returns nil if name should be written or errSkipped if it
+// already exists and should be skipped.
+func checkTarget(name string, mode clobber) error {
+	if _, err := fs.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	switch mode {
+	case clobberForce:
+		return nil
+	case clobberSkip:
+		return errSkipped
+	}
+
+	return fmt.Errorf("%s already exists", name)
+}
+
+// finishTarget renames the partial file to name if err is nil, otherwise the
+// partial file is removed unless keep is set.
+func finishTarget(partial, name string, keep bool, err error) error {
+	if err != nil {
+		if !keep {
+			_ = fs.Remove(partial)
+		}
+		return err
+	}
+	return fs.Rename(partial, name)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/wud"
+	"github.com/hashicorp/go-multierror"
+)
+
+const trimmedSuffix = ".trimmed"
+
+func trim(ctx context.Context, src, dst string, parents []string, mode clobber, verbose bool) error {
+	if dst == "" {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + trimmedSuffix + wud.Extension
+	}
+
+	if err := checkTarget(dst, mode); err != nil {
+		return err
+	}
+
+	rc, err := openFile(src, parents...)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	size, err := wud.TrimmedSize(rc, rc.Size())
+	if err != nil {
+		return err
+	}
+
+	partial := dst + wud.PartialExtension
+
+	f, err := fs.Create(partial)
+	if err != nil {
+		return err
+	}
+
+	var p wud.Progress
+	if verbose {
+		p = newProgress(0)
+	}
+
+	_, err = wud.CopyContext(ctx, f, io.NewSectionReader(rc, 0, size), "", size, p)
+
+	if cerr := f.Close(); cerr != nil {
+		err = multierror.Append(err, cerr)
+	}
+
+	return finishTarget(partial, dst, false, err)
+}
@@ -0,0 +1,162 @@
+package wud
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"errors"
+	"io"
+
+	"go4.org/readerutil"
+)
+
+const (
+	hashedBlockSize = 0x10000
+	hashSize        = 0x400
+	hashedDataSize  = hashedBlockSize - hashSize
+)
+
+// unhashedReader decrypts a content that is one AES-CBC stream.
+type unhashedReader struct {
+	r     io.ReaderAt
+	block cipher.Block
+	iv    []byte
+	size  int64
+}
+
+// NewUnhashedReader returns a readerutil.SizeReaderAt that decrypts the
+// unhashed content read from r, which is size bytes long once decrypted, using
+// the title key in block. The content index is used for the initial IV and
+// reads can start from any offset as the preceding block of ciphertext is
+// used as the IV, so only the blocks that are read from are decrypted.
+func NewUnhashedReader(r io.ReaderAt, block cipher.Block, index uint16, size int64) readerutil.SizeReaderAt {
+	return newUnhashedReader(r, block, index, size)
+}
+
+func newUnhashedReader(r io.ReaderAt, block cipher.Block, index uint16, size int64) *unhashedReader {
+	iv := make([]byte, block.BlockSize())
+	iv[0], iv[1] = byte(index>>8), byte(index)
+	return &unhashedReader{
+		r:     r,
+		block: block,
+		iv:    iv,
+		size:  size,
+	}
+}
+
+func (u *unhashedReader) Size() int64 {
+	return u.size
+}
+
+func (u *unhashedReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= u.size {
+		return 0, io.EOF
+	}
+	if max := u.size - off; int64(len(p)) > max {
+		p = p[0:max]
+		err = io.EOF
+	}
+
+	bs := int64(u.block.BlockSize())
+
+	// Start from the block containing off, the previous block of
+	// ciphertext is the IV for it
+	start := off &^ (bs - 1)
+	end := (off + int64(len(p)) + bs - 1) &^ (bs - 1)
+
+	iv := u.iv
+	if start > 0 {
+		iv = make([]byte, bs)
+		if _, err := u.r.ReadAt(iv, start-bs); err != nil {
+			return 0, err
+		}
+	}
+
+	b := make([]byte, end-start)
+	if _, err := u.r.ReadAt(b, start); err != nil {
+		return 0, err
+	}
+	cipher.NewCBCDecrypter(u.block, iv).CryptBlocks(b, b)
+
+	return copy(p, b[off-start:]), err
+}
+
+// ErrHashMismatch is returned if decrypted data does not match its hash.
+var ErrHashMismatch = errors.New("wud: hash mismatch")
+
+// hashedReader decrypts a content stored as a series of blocks, each with a
+// header containing the hashes of the data and the data itself.
+type hashedReader struct {
+	r      io.ReaderAt
+	block  cipher.Block
+	size   int64
+	verify bool
+}
+
+// NewHashedReader returns a readerutil.SizeReaderAt that decrypts the hashed
+// content read from r, which is size bytes long, using the title key in
+// block. Only the blocks that are read from are decrypted and if verify is
+// set then the data in each of them is checked against its H0 hash.
+func NewHashedReader(r io.ReaderAt, block cipher.Block, size int64, verify bool) readerutil.SizeReaderAt {
+	return newHashedReader(r, block, size, verify)
+}
+
+func newHashedReader(r io.ReaderAt, block cipher.Block, size int64, verify bool) *hashedReader {
+	return &hashedReader{
+		r:      r,
+		block:  block,
+		size:   size / hashedBlockSize * hashedDataSize,
+		verify: verify,
+	}
+}
+
+func (h *hashedReader) Size() int64 {
+	return h.size
+}
+
+// readBlock decrypts the data of block n.
+func (h *hashedReader) readBlock(n int64, b []byte) error {
+	if _, err := h.r.ReadAt(b, n*hashedBlockSize); err != nil {
+		return err
+	}
+
+	// The hashes are encrypted with a zero IV
+	cipher.NewCBCDecrypter(h.block, make([]byte, h.block.BlockSize())).CryptBlocks(b[:hashSize], b[:hashSize])
+
+	// The data uses the start of the H0 hash for this block as the IV
+	h0 := b[(n%16)*sha1.Size : (n%16+1)*sha1.Size]
+	iv := make([]byte, h.block.BlockSize())
+	copy(iv, h0)
+	cipher.NewCBCDecrypter(h.block, iv).CryptBlocks(b[hashSize:], b[hashSize:])
+
+	if h.verify {
+		if sum := sha1.Sum(b[hashSize:]); !bytes.Equal(sum[:], h0) {
+			return ErrHashMismatch
+		}
+	}
+
+	return nil
+}
+
+func (h *hashedReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= h.size {
+		return 0, io.EOF
+	}
+	if max := h.size - off; int64(len(p)) > max {
+		p = p[0:max]
+		err = io.EOF
+	}
+
+	b := make([]byte, hashedBlockSize)
+	for len(p) > 0 {
+		if e := h.readBlock(off/hashedDataSize, b); e != nil {
+			return n, e
+		}
+		m := copy(p, b[hashSize+off%hashedDataSize:])
+		p = p[m:]
+		off += int64(m)
+		n += m
+	}
+
+	return
+}
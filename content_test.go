@@ -0,0 +1,213 @@
+package wud
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var testTitleKey = []byte("0123456789abcdef")
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestUnhashedReader(t *testing.T) {
+	const (
+		index = 0x0102
+		size  = 2*0x8000 + 5
+	)
+
+	block, err := aes.NewCipher(testTitleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The content is encrypted as one stream padded to the block size
+	data := testData(size)
+	b := make([]byte, (size+aes.BlockSize-1)&^(aes.BlockSize-1))
+	copy(b, data)
+	iv := make([]byte, aes.BlockSize)
+	iv[0], iv[1] = index>>8, index&0xff
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(b, b)
+
+	r := NewUnhashedReader(bytes.NewReader(b), block, index, size)
+	if r.Size() != size {
+		t.Fatalf("got size %d, want %d", r.Size(), size)
+	}
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want int
+		err  error
+	}{
+		{"first block", 0, aes.BlockSize, aes.BlockSize, nil},
+		{"unaligned", 3, 10, 10, nil},
+		{"across blocks", 10, 20, 20, nil},
+		{"across cluster", 0x8000 - 7, 30, 30, nil},
+		{"whole", 0, size, size, nil},
+		{"last byte", size - 1, 1, 1, nil},
+		{"across end", size - 3, 10, 3, io.EOF},
+		{"at end", size, 1, 0, io.EOF},
+		{"past end", size + 20, 1, 0, io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.n)
+			n, err := r.ReadAt(p, tt.off)
+			if n != tt.want || err != tt.err {
+				t.Fatalf("got %d, %v, want %d, %v", n, err, tt.want, tt.err)
+			}
+			if n > 0 && !bytes.Equal(p[:n], data[tt.off:tt.off+int64(n)]) {
+				t.Error("data does not match")
+			}
+		})
+	}
+}
+
+// testHashedContent returns the plaintext data of a hashed content of blocks
+// blocks and the content encrypted with block. Each block holds the H0 hashes
+// of the blocks in its subgroup of 16, the H1 hashes of the subgroups in its
+// group of 16 and the H2 hashes of the groups. The H3 hashes of the H2 tables
+// would be held by the TMD.
+func testHashedContent(block cipher.Block, blocks int) ([]byte, []byte) {
+	data := testData(blocks * hashedDataSize)
+
+	// Each table holds 16 hashes
+	table := func(hashes [][sha1.Size]byte, i int) []byte {
+		b := make([]byte, 16*sha1.Size)
+		for j := 0; j < 16 && i*16+j < len(hashes); j++ {
+			copy(b[j*sha1.Size:], hashes[i*16+j][:])
+		}
+		return b
+	}
+	hashTables := func(hashes [][sha1.Size]byte) [][sha1.Size]byte {
+		sums := make([][sha1.Size]byte, (len(hashes)+15)/16)
+		for i := range sums {
+			sums[i] = sha1.Sum(table(hashes, i))
+		}
+		return sums
+	}
+
+	h0 := make([][sha1.Size]byte, blocks)
+	for n := range h0 {
+		h0[n] = sha1.Sum(data[n*hashedDataSize : (n+1)*hashedDataSize])
+	}
+	h1 := hashTables(h0)
+	h2 := hashTables(h1)
+
+	b := make([]byte, blocks*hashedBlockSize)
+	for n := 0; n < blocks; n++ {
+		hashes := b[n*hashedBlockSize : n*hashedBlockSize+hashSize]
+		copy(hashes, table(h0, n/16))
+		copy(hashes[16*sha1.Size:], table(h1, n/256))
+		copy(hashes[32*sha1.Size:], table(h2, n/4096))
+
+		iv := make([]byte, aes.BlockSize)
+		copy(iv, h0[n][:])
+		payload := b[n*hashedBlockSize+hashSize : (n+1)*hashedBlockSize]
+		copy(payload, data[n*hashedDataSize:])
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(payload, payload)
+
+		cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(hashes, hashes)
+	}
+
+	return data, b
+}
+
+func TestHashedReader(t *testing.T) {
+	const blocks = 18
+
+	block, err := aes.NewCipher(testTitleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, b := testHashedContent(block, blocks)
+
+	r := NewHashedReader(bytes.NewReader(b), block, int64(len(b)), true)
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("got size %d, want %d", r.Size(), len(data))
+	}
+
+	size := int64(len(data))
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want int
+		err  error
+	}{
+		{"first block", 0, 100, 100, nil},
+		{"unaligned", 12345, 77, 77, nil},
+		{"across blocks", hashedDataSize - 10, 30, 30, nil},
+		{"across subgroups", 16*hashedDataSize - 5, 10, 10, nil},
+		{"several blocks", 3*hashedDataSize + 1, 3 * hashedDataSize, 3 * hashedDataSize, nil},
+		{"last byte", size - 1, 1, 1, nil},
+		{"across end", size - 3, 10, 3, io.EOF},
+		{"at end", size, 1, 0, io.EOF},
+		{"past end", size + 20, 1, 0, io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.n)
+			n, err := r.ReadAt(p, tt.off)
+			if n != tt.want || err != tt.err {
+				t.Fatalf("got %d, %v, want %d, %v", n, err, tt.want, tt.err)
+			}
+			if n > 0 && !bytes.Equal(p[:n], data[tt.off:tt.off+int64(n)]) {
+				t.Error("data does not match")
+			}
+		})
+	}
+}
+
+func TestHashedReaderMismatch(t *testing.T) {
+	block, err := aes.NewCipher(testTitleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, b := testHashedContent(block, 4)
+
+	// Corrupt the data of the third block
+	b[2*hashedBlockSize+hashSize+100] ^= 0xff
+
+	tests := []struct {
+		name   string
+		off    int64
+		verify bool
+		err    error
+	}{
+		{"good block", hashedDataSize, true, nil},
+		{"bad block", 2*hashedDataSize + 1000, true, ErrHashMismatch},
+		{"across bad block", 2*hashedDataSize - 10, true, ErrHashMismatch},
+		{"unverified", 2*hashedDataSize + 1000, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewHashedReader(bytes.NewReader(b), block, int64(len(b)), tt.verify)
+
+			p := make([]byte, 20)
+			n, err := r.ReadAt(p, tt.off)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && !bytes.Equal(p[:n], data[tt.off:tt.off+int64(n)]) {
+				t.Error("data does not match")
+			}
+		})
+	}
+}
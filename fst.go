@@ -0,0 +1,193 @@
+package wud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	fstMagic uint32 = 0x46535400 // "FST"+0
+
+	fstTypeDirectory = 0x01
+	fstTypeDeleted   = 0x80
+
+	fstFlagOffsetInBytes = 0x0004
+)
+
+type fstHeader struct {
+	Magic            uint32
+	FileOffsetFactor uint32
+	ClusterCount     uint32
+	_                [20]byte
+}
+
+type fstCluster struct {
+	Offset uint32
+	Size   uint32
+	TID    uint64
+	GID    uint32
+	_      [0xc]byte
+}
+
+type fstEntry struct {
+	TypeName            uint32 // 8 + 24
+	Offset              uint32
+	Size                uint32
+	Flags               uint16
+	StorageClusterIndex uint16
+}
+
+type fstFile struct {
+	path    string
+	raw     []byte
+	offset  int64
+	size    int64
+	cluster uint16
+}
+
+type fst struct {
+	clusters []fstCluster
+	files    []fstFile
+	index    map[string]int
+}
+
+// parseFST parses a decrypted file system table, building the full path of
+// each file from the directory entries.
+func parseFST(b []byte) (*fst, error) {
+	br := bytes.NewReader(b)
+
+	fh := fstHeader{}
+	if err := binary.Read(br, binary.BigEndian, &fh); err != nil {
+		return nil, err
+	}
+	if fh.Magic != fstMagic {
+		return nil, errors.New("wud: bad FST magic")
+	}
+	// Each cluster takes 32 bytes so a corrupt count can't be trusted
+	if int64(fh.ClusterCount) > int64(len(b)/32) {
+		return nil, errors.New("wud: bad FST cluster count")
+	}
+
+	t := &fst{
+		clusters: make([]fstCluster, fh.ClusterCount),
+		index:    make(map[string]int),
+	}
+	if err := binary.Read(br, binary.BigEndian, &t.clusters); err != nil {
+		return nil, err
+	}
+
+	fe := fstEntry{}
+	if err := binary.Read(br, binary.BigEndian, &fe); err != nil {
+		return nil, err
+	}
+	// Likewise each entry takes 16 bytes
+	if fe.TypeName>>24 != fstTypeDirectory || fe.TypeName&0xffffff != 0 || fe.Size < 1 || int64(fe.Size) > int64(len(b)/16) {
+		return nil, errors.New("wud: bad root entry")
+	}
+
+	entries := make([]fstEntry, fe.Size)
+	entries[0] = fe
+	if err := binary.Read(br, binary.BigEndian, entries[1:]); err != nil {
+		return nil, err
+	}
+
+	names := b[len(b)-br.Len():]
+	name := func(fe fstEntry) ([]byte, error) {
+		offset := int(fe.TypeName & 0xffffff)
+		if offset >= len(names) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		i := bytes.IndexByte(names[offset:], 0)
+		if i < 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return names[offset : offset+i], nil
+	}
+
+	// Each directory entry records the index of the entry following its
+	// last descendant, so keep a stack of the directories we're in
+	type directory struct {
+		path string
+		raw  []byte
+		end  uint32
+	}
+	stack := []directory{{"", nil, fe.Size}}
+
+	// Every decoded path must be unique for lookups to find the right file
+	paths := make(map[string]struct{})
+	unique := func(p string) error {
+		if _, ok := paths[p]; ok {
+			return fmt.Errorf("wud: duplicate path %s", p)
+		}
+		paths[p] = struct{}{}
+		return nil
+	}
+
+	for i := uint32(1); i < uint32(len(entries)); i++ {
+		for len(stack) > 1 && i >= stack[len(stack)-1].end {
+			stack = stack[:len(stack)-1]
+		}
+
+		fe := entries[i]
+		n, err := name(fe)
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		p := path.Join(parent.path, DecodeName(n, nil))
+		raw := n
+		if parent.raw != nil {
+			raw = append(append(append([]byte{}, parent.raw...), '/'), n...)
+		}
+
+		switch typ := fe.TypeName >> 24; {
+		case typ&fstTypeDirectory != 0:
+			if fe.Size <= i || fe.Size > uint32(len(entries)) {
+				return nil, errors.New("wud: bad directory entry")
+			}
+			if err := unique(p); err != nil {
+				return nil, err
+			}
+			stack = append(stack, directory{p, raw, fe.Size})
+			continue
+		case typ&fstTypeDeleted != 0:
+			continue
+		}
+
+		f := fstFile{
+			path:    p,
+			raw:     raw,
+			offset:  int64(fe.Offset),
+			size:    int64(fe.Size),
+			cluster: fe.StorageClusterIndex,
+		}
+		if fe.Flags&fstFlagOffsetInBytes == 0 {
+			f.offset *= int64(fh.FileOffsetFactor)
+		}
+		if int(f.cluster) >= len(t.clusters) {
+			return nil, errors.New("wud: bad cluster index")
+		}
+
+		if err := unique(p); err != nil {
+			return nil, err
+		}
+
+		t.index[p] = len(t.files)
+		t.files = append(t.files, f)
+	}
+
+	return t, nil
+}
+
+func (t *fst) lookup(name string) (fstFile, bool) {
+	i, ok := t.index[strings.TrimPrefix(path.Clean("/"+name), "/")]
+	if !ok {
+		return fstFile{}, false
+	}
+	return t.files[i], true
+}
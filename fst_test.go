@@ -0,0 +1,147 @@
+package wud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testFST builds a raw FST with clusters clusters, entries following the root
+// entry and names as the name table.
+func testFST(clusters uint32, root uint32, entries []fstEntry, names string) []byte {
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.BigEndian, fstHeader{Magic: fstMagic, FileOffsetFactor: 0x20, ClusterCount: clusters})
+	_ = binary.Write(b, binary.BigEndian, make([]fstCluster, clusters))
+	_ = binary.Write(b, binary.BigEndian, fstEntry{TypeName: fstTypeDirectory << 24, Size: root})
+	_ = binary.Write(b, binary.BigEndian, entries)
+	b.WriteString(names)
+	return b.Bytes()
+}
+
+func TestParseFST(t *testing.T) {
+	const names = "\x00code\x00app.rpx\x00meta.xml\x00"
+	tree := []fstEntry{
+		{TypeName: fstTypeDirectory<<24 | 1, Size: 3},
+		{TypeName: 6, Offset: 2, Size: 100, StorageClusterIndex: 1},
+		{TypeName: 14, Offset: 0x40, Size: 10, Flags: fstFlagOffsetInBytes},
+	}
+
+	tests := []struct {
+		name  string
+		b     []byte
+		files []fstFile
+		err   bool
+	}{
+		{
+			"tree",
+			testFST(2, 4, tree, names),
+			[]fstFile{
+				{path: "code/app.rpx", raw: []byte("code/app.rpx"), offset: 0x40, size: 100, cluster: 1},
+				{path: "meta.xml", raw: []byte("meta.xml"), offset: 0x40, size: 10},
+			},
+			false,
+		},
+		{
+			"deleted",
+			testFST(1, 2, []fstEntry{{TypeName: fstTypeDeleted<<24 | 14}}, names),
+			nil,
+			false,
+		},
+		{
+			"empty name",
+			testFST(1, 2, []fstEntry{{Size: 10}}, names),
+			[]fstFile{
+				{path: "_", size: 10},
+			},
+			false,
+		},
+		{
+			"duplicate path",
+			testFST(1, 3, []fstEntry{{TypeName: 14}, {TypeName: 14}}, names),
+			nil,
+			true,
+		},
+		{
+			"duplicate directory",
+			testFST(2, 4, []fstEntry{{TypeName: fstTypeDirectory<<24 | 1, Size: 2}, {TypeName: fstTypeDirectory<<24 | 1, Size: 4}, tree[1]}, names),
+			nil,
+			true,
+		},
+		{
+			"bad magic",
+			append([]byte{0}, testFST(2, 4, tree, names)[1:]...),
+			nil,
+			true,
+		},
+		{
+			"too many clusters",
+			testFST(2, 4, tree, names)[:0x20],
+			nil,
+			true,
+		},
+		{
+			"empty root",
+			testFST(2, 0, nil, names),
+			nil,
+			true,
+		},
+		{
+			"too many entries",
+			testFST(2, 0xffffffff, tree, names),
+			nil,
+			true,
+		},
+		{
+			"truncated entries",
+			testFST(2, 5, tree, ""),
+			nil,
+			true,
+		},
+		{
+			"bad directory",
+			testFST(2, 4, append([]fstEntry{{TypeName: fstTypeDirectory<<24 | 1, Size: 5}}, tree[1:]...), names),
+			nil,
+			true,
+		},
+		{
+			"bad cluster",
+			testFST(1, 4, tree, names),
+			nil,
+			true,
+		},
+		{
+			"bad name",
+			testFST(2, 2, []fstEntry{{TypeName: 0x100}}, names),
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fst, err := parseFST(tt.b)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fst.files) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(fst.files), len(tt.files))
+			}
+			for i, want := range tt.files {
+				got := fst.files[i]
+				if got.path != want.path || !bytes.Equal(got.raw, want.raw) || got.offset != want.offset || got.size != want.size || got.cluster != want.cluster {
+					t.Errorf("got %+v, want %+v", got, want)
+				}
+				if f, ok := fst.lookup("/" + want.path); !ok || f.path != want.path {
+					t.Errorf("lookup %s failed", want.path)
+				}
+			}
+		})
+	}
+}
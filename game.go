@@ -0,0 +1,290 @@
+package wud
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"go4.org/readerutil"
+)
+
+const contentHashed = 0x2
+
+type titleMetadata struct {
+	SignatureType    uint32
+	Signature        [0x100]byte
+	_                [0x3c]byte
+	Issuer           [0x40]byte
+	Version          byte
+	CACRLVersion     byte
+	SignerCRLVersion byte
+	_                byte
+	SystemVersion    uint64
+	TitleID          uint64
+	TitleType        uint32
+	GroupID          uint16
+	_                [62]byte
+	AccessRights     uint32
+	TitleVersion     uint16
+	ContentCount     uint16
+	BootIndex        uint16
+	_                [2]byte
+	SHA2             [sha256.Size]byte
+
+	ContentInfos [64]struct {
+		IndexOffset  uint16
+		CommandCount uint16
+		SHA2         [sha256.Size]byte
+	}
+}
+
+// Content describes a content listed in the title metadata.
+type Content struct {
+	ID    uint32
+	Index uint16
+	Type  uint16
+	Size  uint64
+	SHA2  [sha256.Size]byte
+}
+
+// Hashed returns whether the content is stored with a hash tree.
+func (c Content) Hashed() bool {
+	return c.Type&contentHashed != 0
+}
+
+// gamePartition represents the decrypted metadata of the GM partition
+// belonging to the title found in the SI partition.
+type gamePartition struct {
+	r        io.ReaderAt
+	offset   int64
+	tmd      titleMetadata
+	contents []Content
+	key      cipher.Block
+	fst      *fst
+}
+
+// gamePartition parses the title metadata and ticket from the SI partition,
+// decrypts the title key and then uses that to decrypt the FST in the
+// matching GM partition. The result is cached.
+func (w *WUD) gamePartition() (*gamePartition, error) {
+	w.gmOnce.Do(func() {
+		w.gm, w.gmErr = w.newGamePartition()
+	})
+	return w.gm, w.gmErr
+}
+
+// titleMetadata parses the title metadata and the list of contents from the
+// SI partition, only the disc key is required.
+func (w *WUD) titleMetadata() (*titleMetadata, []Content, error) {
+	f, ok := w.files[titleTmd]
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+	r := f.reader(w.r, w.game)
+
+	tmd := new(titleMetadata)
+	if err := binary.Read(r, binary.BigEndian, tmd); err != nil {
+		return nil, nil, err
+	}
+
+	contents := make([]Content, tmd.ContentCount)
+	if err := binary.Read(r, binary.BigEndian, &contents); err != nil {
+		return nil, nil, err
+	}
+	if len(contents) == 0 {
+		return nil, nil, errors.New("wud: no contents")
+	}
+
+	return tmd, contents, nil
+}
+
+func (w *WUD) newGamePartition() (*gamePartition, error) {
+	gm := &gamePartition{
+		r: w.r,
+	}
+
+	tmd, contents, err := w.titleMetadata()
+	if err != nil {
+		return nil, err
+	}
+	gm.tmd, gm.contents = *tmd, contents
+
+	if _, gm.offset, err = w.pt.findPartition(fmt.Sprintf("GM%016X", gm.tmd.TitleID)); err != nil {
+		return nil, err
+	}
+
+	if gm.key, err = w.titleKey(); err != nil {
+		return nil, err
+	}
+
+	// The FST is always the first content
+	b := new(bytes.Buffer)
+	if _, err = io.Copy(b, io.NewSectionReader(gm.contentReader(0, false), 0, int64(gm.contents[0].Size))); err != nil {
+		return nil, err
+	}
+
+	// A bad title key results in a bad FST magic
+	if magic := b.Bytes(); len(magic) < 4 || binary.BigEndian.Uint32(magic) != fstMagic {
+		return nil, ErrWrongCommonKey
+	}
+
+	if gm.fst, err = parseFST(b.Bytes()); err != nil {
+		return nil, err
+	}
+	if len(gm.fst.clusters) != len(gm.contents) {
+		return nil, errors.New("wud: FST and TMD content count mismatch")
+	}
+
+	return gm, nil
+}
+
+// titleKey decrypts the title key from the ticket using the common key.
+func (w *WUD) titleKey() (cipher.Block, error) {
+	if w.common == nil {
+		return nil, ErrNoCommonKey
+	}
+
+	f, ok := w.files[titleTik]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	r := f.reader(w.r, w.game)
+
+	if _, err := io.CopyN(ioutil.Discard, r, 0x1bf); err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, 0x1dc-(aes.BlockSize+0x1bf)); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, w.common.BlockSize())
+	if _, err := io.ReadFull(r, iv[:8]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(w.common, iv).CryptBlocks(key, key)
+
+	return aes.NewCipher(key)
+}
+
+// contentOffset returns the absolute offset of content i.
+func (gm *gamePartition) contentOffset(i int) int64 {
+	if i == 0 || gm.fst == nil {
+		return gm.offset + int64(SectorSize)
+	}
+	return gm.offset + int64(gm.fst.clusters[i].Offset)*int64(SectorSize)
+}
+
+// contentReader returns an io.ReaderAt that decrypts content i, verifying
+// the hashes of hashed contents if verify is set.
+func (gm *gamePartition) contentReader(i int, verify bool) readerutil.SizeReaderAt {
+	c := gm.contents[i]
+	bs := int64(gm.key.BlockSize())
+	sr := io.NewSectionReader(gm.r, gm.contentOffset(i), (int64(c.Size)+bs-1)&(-bs))
+	if c.Hashed() {
+		return newHashedReader(sr, gm.key, int64(c.Size), verify)
+	}
+	return newUnhashedReader(sr, gm.key, c.Index, int64(c.Size))
+}
+
+// open returns a reader for the decrypted game file name.
+func (gm *gamePartition) open(name string) (*io.SectionReader, error) {
+	f, ok := gm.fst.lookup(name)
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	for i, c := range gm.contents {
+		if c.Index == f.cluster {
+			return io.NewSectionReader(gm.contentReader(i, false), f.offset, f.size), nil
+		}
+	}
+
+	return nil, errors.New("wud: content not found")
+}
+
+// Open returns a reader for the decrypted game file name, such as
+// "code/app.xml". Only the blocks of the containing content that are read
+// from are decrypted.
+func (w *WUD) Open(name string) (*io.SectionReader, error) {
+	gm, err := w.gamePartition()
+	if err != nil {
+		return nil, err
+	}
+
+	return gm.open(name)
+}
+
+// ReadFile returns the contents of the decrypted game file name, such as
+// "meta/meta.xml".
+func (w *WUD) ReadFile(name string) ([]byte, error) {
+	sr, err := w.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(sr)
+}
+
+// Contents returns the contents listed in the title metadata of the game
+// partition. Only the disc key is required.
+func (w *WUD) Contents() ([]Content, error) {
+	_, contents, err := w.titleMetadata()
+	return contents, err
+}
+
+// OpenContent returns a reader for the decrypted content with the passed ID.
+// Only the blocks that are read from are decrypted and if verify is set then
+// the data of a hashed content is checked against its hashes as it is read.
+func (w *WUD) OpenContent(id uint32, verify bool) (readerutil.SizeReaderAt, error) {
+	gm, err := w.gamePartition()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range gm.contents {
+		if c.ID == id {
+			return gm.contentReader(i, verify), nil
+		}
+	}
+
+	return nil, errors.New("wud: content not found")
+}
+
+// GameFile describes a decrypted game file.
+type GameFile struct {
+	Path      string // Full path, such as "meta/meta.xml"
+	RawPath   []byte // Full path before any names were decoded
+	Offset    int64  // Offset within the content
+	Size      int64
+	ContentID uint32
+}
+
+// Files returns every decrypted game file listed in the FST of the game
+// partition.
+func (w *WUD) Files() ([]GameFile, error) {
+	gm, err := w.gamePartition()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[uint16]uint32)
+	for _, c := range gm.contents {
+		ids[c.Index] = c.ID
+	}
+
+	files := make([]GameFile, 0, len(gm.fst.files))
+	for _, f := range gm.fst.files {
+		files = append(files, GameFile{f.path, f.raw, f.offset, f.size, ids[f.cluster]})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,245 @@
+package wud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommonKeyEnv is the environment variable that can hold the common key as a
+// hexadecimal string.
+const CommonKeyEnv = "WUD_COMMON_KEY"
+
+// ErrBadKey is returned if a key is not 16 bytes encoded as hexadecimal.
+var ErrBadKey = errors.New("wud: bad key")
+
+var (
+	productCodeRegexp = regexp.MustCompile(`\bWUP-[A-Z]-[A-Z0-9]{4}\b`)
+	titleIDRegexp     = regexp.MustCompile(`\b[0-9A-Fa-f]{16}\b`)
+)
+
+// IsProductCode returns whether s is a disc product code, such as
+// "WUP-P-ABCD".
+func IsProductCode(s string) bool {
+	return s != "" && productCodeRegexp.FindString(s) == s
+}
+
+// ParseKey parses a key from a hexadecimal string such as
+// "d7b00402659ba2abd2cb0db27fa2b656".
+func ParseKey(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != keySize {
+		return nil, ErrBadKey
+	}
+	return b, nil
+}
+
+// CommonKeyFromEnv returns the common key held in the CommonKeyEnv
+// environment variable, or nil if it is not set.
+func CommonKeyFromEnv() ([]byte, error) {
+	s, ok := os.LookupEnv(CommonKeyEnv)
+	if !ok || s == "" {
+		return nil, nil
+	}
+	return ParseKey(s)
+}
+
+// KeyDB is a database of keys read from a Cemu-style keys.txt file. Each
+// line holds a key as a hexadecimal string, optionally followed by a comment
+// starting with "#". Disc keys are matched to a disc by a product code, such
+// as "WUP-P-ABCD", anywhere in the comment and a comment of just "common"
+// marks the common key:
+//
+//	# Comment
+//	d7b00402659ba2abd2cb0db27fa2b656 # common
+//	00112233445566778899aabbccddeeff # WUP-P-ABCD Title name
+//	ffeeddccbbaa99887766554433221100 # 0005000010101a00
+//
+// A title ID anywhere in the comment also matches the key to that title.
+type KeyDB struct {
+	// Common is the common key, or nil if there isn't one.
+	Common []byte
+	// Disc holds the disc keys, keyed by product code.
+	Disc map[string][]byte
+	// Comments holds the rest of the comment for each disc key, such as
+	// the title ID or name, keyed by product code.
+	Comments map[string]string
+	// Title holds the disc keys, keyed by the title ID of the game.
+	Title map[uint64][]byte
+	// Unlabelled holds any other keys.
+	Unlabelled [][]byte
+}
+
+// NewKeyDB returns an empty key database.
+func NewKeyDB() *KeyDB {
+	return &KeyDB{
+		Disc:     make(map[string][]byte),
+		Comments: make(map[string]string),
+		Title:    make(map[uint64][]byte),
+	}
+}
+
+// KeyDBError is returned by ReadKeyDB if any lines don't hold a valid key.
+type KeyDBError struct {
+	// Lines holds the number of each bad line, starting from 1.
+	Lines []int
+}
+
+func (e *KeyDBError) Error() string {
+	lines := make([]string, len(e.Lines))
+	for i, n := range e.Lines {
+		lines[i] = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("wud: bad key on line %s", strings.Join(lines, ", "))
+}
+
+// ReadKeyDB reads a key database from r. Any lines that don't hold a valid
+// key are skipped and reported with a *KeyDBError, the database holding the
+// keys from the other lines is still returned.
+func ReadKeyDB(r io.Reader) (*KeyDB, error) {
+	db := NewKeyDB()
+
+	var bad []int
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line, comment := s.Text(), ""
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line, comment = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		key, err := ParseKey(line)
+		if err != nil {
+			bad = append(bad, n)
+			continue
+		}
+
+		tid, terr := strconv.ParseUint(titleIDRegexp.FindString(comment), 16, 64)
+		if terr == nil {
+			db.Title[tid] = key
+		}
+
+		switch code := productCodeRegexp.FindString(comment); {
+		case strings.EqualFold(comment, "common"):
+			db.Common = key
+		case code != "":
+			db.Disc[code] = key
+			if c := strings.Join(strings.Fields(strings.Replace(comment, code, "", 1)), " "); c != "" {
+				db.Comments[code] = c
+			}
+		case terr != nil:
+			db.Unlabelled = append(db.Unlabelled, key)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(bad) > 0 {
+		return db, &KeyDBError{bad}
+	}
+
+	return db, nil
+}
+
+// DiscKey returns the disc key for the disc with the passed product code.
+func (db *KeyDB) DiscKey(productCode string) ([]byte, bool) {
+	key, ok := db.Disc[productCode]
+	return key, ok
+}
+
+// TitleDiscKey returns the disc key for the game with the passed title ID.
+func (db *KeyDB) TitleDiscKey(titleID uint64) ([]byte, bool) {
+	key, ok := db.Title[titleID]
+	return key, ok
+}
+
+// Merge adds all of the keys in other to db, replacing any existing keys.
+func (db *KeyDB) Merge(other *KeyDB) {
+	if other.Common != nil {
+		db.Common = other.Common
+	}
+	for code, key := range other.Disc {
+		db.Disc[code] = key
+		delete(db.Comments, code)
+		if c, ok := other.Comments[code]; ok {
+			db.Comments[code] = c
+		}
+	}
+	for tid, key := range other.Title {
+		db.Title[tid] = key
+	}
+	for _, key := range other.Unlabelled {
+		if !db.hasUnlabelled(key) {
+			db.Unlabelled = append(db.Unlabelled, key)
+		}
+	}
+}
+
+func (db *KeyDB) hasUnlabelled(key []byte) bool {
+	for _, k := range db.Unlabelled {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes db to w in the same format read by ReadKeyDB with the disc
+// keys sorted by product code followed by any title IDs that aren't already
+// in the comment of a disc key.
+func (db *KeyDB) WriteTo(w io.Writer) (int64, error) {
+	b := new(bytes.Buffer)
+
+	if db.Common != nil {
+		fmt.Fprintf(b, "%x # common\n", db.Common)
+	}
+
+	codes := make([]string, 0, len(db.Disc))
+	for code := range db.Disc {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	written := make(map[uint64]bool)
+	for _, code := range codes {
+		fmt.Fprintf(b, "%x # %s", db.Disc[code], code)
+		if c, ok := db.Comments[code]; ok {
+			fmt.Fprintf(b, " %s", c)
+			if tid, err := strconv.ParseUint(titleIDRegexp.FindString(c), 16, 64); err == nil && bytes.Equal(db.Title[tid], db.Disc[code]) {
+				written[tid] = true
+			}
+		}
+		fmt.Fprintln(b)
+	}
+
+	tids := make([]uint64, 0, len(db.Title))
+	for tid := range db.Title {
+		if !written[tid] {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+
+	for _, tid := range tids {
+		fmt.Fprintf(b, "%x # %016x\n", db.Title[tid], tid)
+	}
+
+	for _, key := range db.Unlabelled {
+		fmt.Fprintf(b, "%x\n", key)
+	}
+
+	return b.WriteTo(w)
+}
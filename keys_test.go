@@ -0,0 +1,125 @@
+package wud
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []byte
+		err  error
+	}{
+		{"lower", "d7b00402659ba2abd2cb0db27fa2b656", []byte{0xd7, 0xb0, 0x04, 0x02, 0x65, 0x9b, 0xa2, 0xab, 0xd2, 0xcb, 0x0d, 0xb2, 0x7f, 0xa2, 0xb6, 0x56}, nil},
+		{"upper and spaces", " D7B00402659BA2ABD2CB0DB27FA2B656\n", []byte{0xd7, 0xb0, 0x04, 0x02, 0x65, 0x9b, 0xa2, 0xab, 0xd2, 0xcb, 0x0d, 0xb2, 0x7f, 0xa2, 0xb6, 0x56}, nil},
+		{"short", "d7b00402659ba2abd2cb0db27fa2b6", nil, ErrBadKey},
+		{"long", "d7b00402659ba2abd2cb0db27fa2b65600", nil, ErrBadKey},
+		{"not hex", "z7b00402659ba2abd2cb0db27fa2b656", nil, ErrBadKey},
+		{"empty", "", nil, ErrBadKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.s)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsProductCode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"WUP-P-ABCD", true},
+		{"WUP-P-AB12", true},
+		{"wup-p-abcd", false},
+		{"WUP-P-ABC", false},
+		{"WUP-P-ABCDE", false},
+		{"WUP-P-ABCD ", false},
+		{"x WUP-P-ABCD", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsProductCode(tt.s); got != tt.want {
+			t.Errorf("IsProductCode(%q) got %t, want %t", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestKeyDB(t *testing.T) {
+	const keys = `# Comment
+d7b00402659ba2abd2cb0db27fa2b656 # common
+00112233445566778899aabbccddeeff # WUP-P-ABCD  Title name
+ffeeddccbbaa99887766554433221100 # 0005000010101a00 Other title
+0123456789abcdef0123456789abcdef # WUP-P-EFGH 000500001010ec00
+not a key # WUP-P-IJKL
+
+fedcba9876543210fedcba9876543210
+0011 # too short
+`
+
+	tests := []struct {
+		name string
+		f    func(*KeyDB) interface{}
+		want interface{}
+	}{
+		{"common", func(db *KeyDB) interface{} { return db.Common }, mustParseKey("d7b00402659ba2abd2cb0db27fa2b656")},
+		{"disc", func(db *KeyDB) interface{} { key, _ := db.DiscKey("WUP-P-ABCD"); return key }, mustParseKey("00112233445566778899aabbccddeeff")},
+		{"comment", func(db *KeyDB) interface{} { return db.Comments["WUP-P-ABCD"] }, "Title name"},
+		{"title", func(db *KeyDB) interface{} { key, _ := db.TitleDiscKey(0x0005000010101a00); return key }, mustParseKey("ffeeddccbbaa99887766554433221100")},
+		{"disc and title", func(db *KeyDB) interface{} { key, _ := db.TitleDiscKey(0x000500001010ec00); return key }, mustParseKey("0123456789abcdef0123456789abcdef")},
+		{"bad line", func(db *KeyDB) interface{} { _, ok := db.DiscKey("WUP-P-IJKL"); return ok }, false},
+		{"unlabelled", func(db *KeyDB) interface{} { return db.Unlabelled }, [][]byte{mustParseKey("fedcba9876543210fedcba9876543210")}},
+	}
+
+	db, err := ReadKeyDB(strings.NewReader(keys))
+	var kerr *KeyDBError
+	if !errors.As(err, &kerr) || !reflect.DeepEqual(kerr.Lines, []int{6, 9}) {
+		t.Fatalf("got error %v, want bad lines 6 and 9", err)
+	}
+
+	b := new(bytes.Buffer)
+	if _, err = db.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys should survive being written out and read back in
+	again, err := ReadKeyDB(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, db := range []*KeyDB{db, again} {
+				if got := tt.f(db); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(db, again) {
+		t.Errorf("got %+v after round trip, want %+v", again, db)
+	}
+}
+
+func mustParseKey(s string) []byte {
+	key, err := ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
@@ -0,0 +1,365 @@
+package wud
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	metaFile = "meta/meta.xml"
+	appFile  = "code/app.xml"
+	cosFile  = "code/cos.xml"
+)
+
+// The artwork and sound found in the meta directory of a title.
+const (
+	IconTexFile     = "meta/iconTex.tga"
+	BootTvTexFile   = "meta/bootTvTex.tga"
+	BootDrcTexFile  = "meta/bootDrcTex.tga"
+	BootLogoTexFile = "meta/bootLogoTex.tga"
+	BootSoundFile   = "meta/bootSound.btsnd"
+)
+
+// Language is the suffix used for localised elements in meta.xml.
+type Language string
+
+// The languages present in meta.xml.
+const (
+	Japanese           Language = "ja"
+	English            Language = "en"
+	French             Language = "fr"
+	German             Language = "de"
+	Italian            Language = "it"
+	Spanish            Language = "es"
+	ChineseSimplified  Language = "zhs"
+	Korean             Language = "ko"
+	Dutch              Language = "nl"
+	Portuguese         Language = "pt"
+	Russian            Language = "ru"
+	ChineseTraditional Language = "zht"
+)
+
+// Languages lists every Language in the order they appear in meta.xml.
+var Languages = []Language{
+	Japanese,
+	English,
+	French,
+	German,
+	Italian,
+	Spanish,
+	ChineseSimplified,
+	Korean,
+	Dutch,
+	Portuguese,
+	Russian,
+	ChineseTraditional,
+}
+
+// Region is a bitmask of the regions a title can be used in.
+type Region uint32
+
+// The individual Region flags.
+const (
+	RegionJapan  Region = 1 << 0
+	RegionUSA    Region = 1 << 1
+	RegionEurope Region = 1 << 2
+	RegionChina  Region = 1 << 4
+	RegionKorea  Region = 1 << 5
+	RegionTaiwan Region = 1 << 6
+)
+
+var regionNames = []struct {
+	r    Region
+	name string
+}{
+	{RegionJapan, "JPN"},
+	{RegionUSA, "USA"},
+	{RegionEurope, "EUR"},
+	{RegionChina, "CHN"},
+	{RegionKorea, "KOR"},
+	{RegionTaiwan, "TWN"},
+}
+
+func (r Region) String() string {
+	if r == 0xffffffff {
+		return "ALL"
+	}
+	names := []string{}
+	for _, n := range regionNames {
+		if r&n.r != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("0x%08x", uint32(r))
+	}
+	return strings.Join(names, ",")
+}
+
+// Meta represents the title metadata found in meta/meta.xml.
+type Meta struct {
+	Version         uint32
+	ProductCode     string
+	ContentPlatform string
+	CompanyCode     string
+	MasteringDate   string
+	TitleID         uint64
+	TitleVersion    uint32
+	GroupID         uint32
+	OSVersion       uint64
+	AppSize         uint64
+	Region          Region
+	LongNames       map[Language]string
+	ShortNames      map[Language]string
+	Publishers      map[Language]string
+}
+
+// App represents the application information found in code/app.xml.
+type App struct {
+	Version      uint32
+	OSVersion    uint64
+	TitleID      uint64
+	TitleVersion uint32
+	SDKVersion   uint32
+	AppType      uint32
+	GroupID      uint32
+	OSMask       string
+}
+
+// Permission represents a permission requested in code/cos.xml.
+type Permission struct {
+	Name  string
+	Group uint32
+	Mask  uint64
+}
+
+// Cos represents the OS configuration found in code/cos.xml.
+type Cos struct {
+	Version     uint32
+	ArgString   string
+	AvailSize   uint32
+	CodegenSize uint32
+	CodegenCore uint32
+	MaxSize     uint32
+	MaxCodeSize uint32
+	Permissions []Permission
+}
+
+// element is any XML element with its children, the files use the element
+// name as the key and a type attribute to describe the encoding.
+type element struct {
+	XMLName  xml.Name
+	Type     string    `xml:"type,attr"`
+	Value    string    `xml:",chardata"`
+	Children []element `xml:",any"`
+}
+
+type elements map[string]element
+
+func parseElements(b []byte, root string) (elements, error) {
+	e := element{}
+	if err := xml.Unmarshal(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), &e); err != nil {
+		return nil, err
+	}
+	if e.XMLName.Local != root {
+		return nil, fmt.Errorf("wud: bad root element %q", e.XMLName.Local)
+	}
+	return e.elements(), nil
+}
+
+func (e element) elements() elements {
+	m := make(elements)
+	for _, c := range e.Children {
+		m[c.XMLName.Local] = c
+	}
+	return m
+}
+
+func (m elements) string(name string) string {
+	return strings.TrimSpace(m[name].Value)
+}
+
+// uint parses the named element, a hexBinary type is always hexadecimal
+// otherwise it's decimal.
+func (m elements) uint(name string, bitSize int) (uint64, error) {
+	e, ok := m[name]
+	if !ok {
+		return 0, nil
+	}
+	s := strings.TrimSpace(e.Value)
+	if s == "" {
+		return 0, nil
+	}
+	base := 10
+	if e.Type == "hexBinary" {
+		base = 16
+	}
+	v, err := strconv.ParseUint(s, base, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("wud: bad %s value: %w", name, err)
+	}
+	return v, nil
+}
+
+func (m elements) localised(prefix string) map[Language]string {
+	l := make(map[Language]string)
+	for _, lang := range Languages {
+		if s := m.string(prefix + string(lang)); s != "" {
+			l[lang] = s
+		}
+	}
+	return l
+}
+
+// parseUints parses each named element into the matching destination which
+// must be a *uint32 or *uint64.
+func (m elements) parseUints(dst map[string]interface{}) error {
+	for name, d := range dst {
+		switch d := d.(type) {
+		case *uint32:
+			v, err := m.uint(name, 32)
+			if err != nil {
+				return err
+			}
+			*d = uint32(v)
+		case *uint64:
+			v, err := m.uint(name, 64)
+			if err != nil {
+				return err
+			}
+			*d = v
+		}
+	}
+	return nil
+}
+
+func parseMeta(b []byte) (*Meta, error) {
+	m, err := parseElements(b, "menu")
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Meta{
+		ProductCode:     m.string("product_code"),
+		ContentPlatform: m.string("content_platform"),
+		CompanyCode:     m.string("company_code"),
+		MasteringDate:   m.string("mastering_date"),
+		LongNames:       m.localised("longname_"),
+		ShortNames:      m.localised("shortname_"),
+		Publishers:      m.localised("publisher_"),
+	}
+
+	var region uint32
+	if err = m.parseUints(map[string]interface{}{
+		"version":       &meta.Version,
+		"title_id":      &meta.TitleID,
+		"title_version": &meta.TitleVersion,
+		"group_id":      &meta.GroupID,
+		"os_version":    &meta.OSVersion,
+		"app_size":      &meta.AppSize,
+		"region":        &region,
+	}); err != nil {
+		return nil, err
+	}
+	meta.Region = Region(region)
+
+	return meta, nil
+}
+
+func parseApp(b []byte) (*App, error) {
+	m, err := parseElements(b, "app")
+	if err != nil {
+		return nil, err
+	}
+
+	app := &App{
+		OSMask: m.string("os_mask"),
+	}
+
+	if err = m.parseUints(map[string]interface{}{
+		"version":       &app.Version,
+		"os_version":    &app.OSVersion,
+		"title_id":      &app.TitleID,
+		"title_version": &app.TitleVersion,
+		"sdk_version":   &app.SDKVersion,
+		"app_type":      &app.AppType,
+		"group_id":      &app.GroupID,
+	}); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+func parseCos(b []byte) (*Cos, error) {
+	m, err := parseElements(b, "app")
+	if err != nil {
+		return nil, err
+	}
+
+	cos := &Cos{
+		ArgString: m.string("argstr"),
+	}
+
+	if err = m.parseUints(map[string]interface{}{
+		"version":      &cos.Version,
+		"avail_size":   &cos.AvailSize,
+		"codegen_size": &cos.CodegenSize,
+		"codegen_core": &cos.CodegenCore,
+		"max_size":     &cos.MaxSize,
+		"max_codesize": &cos.MaxCodeSize,
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, p := range m["permissions"].Children {
+		pm := p.elements()
+		perm := Permission{
+			Name: p.XMLName.Local,
+		}
+		if err = pm.parseUints(map[string]interface{}{
+			"group": &perm.Group,
+			"mask":  &perm.Mask,
+		}); err != nil {
+			return nil, err
+		}
+		cos.Permissions = append(cos.Permissions, perm)
+	}
+
+	return cos, nil
+}
+
+// Meta returns the title metadata parsed from meta/meta.xml.
+func (w *WUD) Meta() (*Meta, error) {
+	b, err := w.ReadFile(metaFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseMeta(b)
+}
+
+// App returns the application information parsed from code/app.xml.
+func (w *WUD) App() (*App, error) {
+	b, err := w.ReadFile(appFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseApp(b)
+}
+
+// Cos returns the OS configuration parsed from code/cos.xml.
+func (w *WUD) Cos() (*Cos, error) {
+	b, err := w.ReadFile(cosFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseCos(b)
+}
+
+// ProductCode returns the product code of the disc, such as "WUP-P-ABCD".
+func (w *WUD) ProductCode() string {
+	return w.title
+}
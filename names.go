@@ -0,0 +1,78 @@
+package wud
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// emptyName replaces an empty raw FST name so that it isn't folded into its
+// parent directory.
+const emptyName = "_"
+
+// DecodeName decodes a raw FST name which is either UTF-8 or, as used by
+// Japanese releases, Shift-JIS. If the name is neither, or would not be a
+// usable filename, then escape is used to encode it, or EscapeName if escape
+// is nil. An empty name is decoded as "_".
+func DecodeName(raw []byte, escape func([]byte) string) string {
+	if escape == nil {
+		escape = EscapeName
+	}
+
+	switch s := string(raw); s {
+	case "":
+		return emptyName
+	case ".", "..":
+		return escape(raw)
+	}
+
+	if utf8.Valid(raw) {
+		if s := string(raw); usableName(s) {
+			return s
+		}
+		return escape(raw)
+	}
+
+	if b, err := japanese.ShiftJIS.NewDecoder().Bytes(raw); err == nil && !bytes.ContainsRune(b, utf8.RuneError) && usableName(string(b)) {
+		return string(b)
+	}
+
+	return escape(raw)
+}
+
+// usableName returns whether the decoded name s is free of control
+// characters and path separators.
+func usableName(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) || r == '/' || r == '\\'
+	}) < 0
+}
+
+// EscapeName encodes every byte of a raw FST name that isn't printable ASCII,
+// along with '%' itself, path separators and any leading '.', as %XX so that
+// it can be used as a filename and decoded again.
+func EscapeName(raw []byte) string {
+	var sb strings.Builder
+	for i, c := range raw {
+		if c <= ' ' || c >= 0x7f || c == '%' || c == '/' || c == '\\' || (c == '.' && i == 0) {
+			fmt.Fprintf(&sb, "%%%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// decodePath decodes each name in the raw path separated by '/'.
+func decodePath(raw []byte, escape func([]byte) string) string {
+	names := bytes.Split(raw, []byte("/"))
+	decoded := make([]string, len(names))
+	for i, name := range names {
+		decoded[i] = DecodeName(name, escape)
+	}
+	return strings.Join(decoded, "/")
+}
@@ -0,0 +1,63 @@
+package wud
+
+import "testing"
+
+func TestDecodeName(t *testing.T) {
+	underscore := func(raw []byte) string {
+		b := make([]byte, len(raw))
+		for i := range raw {
+			b[i] = '_'
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name   string
+		raw    []byte
+		escape func([]byte) string
+		want   string
+	}{
+		{"ascii", []byte("meta.xml"), nil, "meta.xml"},
+		{"utf-8", []byte("ファイル.txt"), nil, "ファイル.txt"},
+		{"shift-jis", []byte{0x83, 0x74, 0x83, 0x40, 0x83, 0x43, 0x83, 0x8b}, nil, "ファイル"},
+		{"invalid", []byte{0xff, 'a'}, nil, "%FFa"},
+		{"empty", []byte{}, nil, "_"},
+		{"empty custom escape", []byte{}, underscore, "_"},
+		{"dot", []byte("."), nil, "%2E"},
+		{"dot dot", []byte(".."), nil, "%2E."},
+		{"slash", []byte("a/b"), nil, "a%2Fb"},
+		{"backslash", []byte(`a\b`), nil, "a%5Cb"},
+		{"control", []byte("a\nb"), nil, "a%0Ab"},
+		{"utf-8 with control", []byte("ファ\x7fイル"), nil, "%E3%83%95%E3%82%A1%7F%E3%82%A4%E3%83%AB"},
+		{"custom escape", []byte{0xff, 'a'}, underscore, "__"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeName(tt.raw, tt.escape); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"meta.xml", "meta.xml"},
+		{".hidden", "%2Ehidden"},
+		{"a.b.", "a.b."},
+		{"100%", "100%25"},
+		{"a b", "a%20b"},
+		{"a/b\\c", "a%2Fb%5Cc"},
+		{"\x00\x7f\x80", "%00%7F%80"},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeName([]byte(tt.raw)); got != tt.want {
+			t.Errorf("EscapeName(%q) got %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+/*
+Package nbd implements a read-only Network Block Device server using the fixed
+newstyle handshake. Any readerutil.SizeReaderAt can be exported, such as a
+decompressed Nintendo Wii-U disc image, which allows tools that expect a raw
+block device or file to read from it without decompressing it first.
+
+If the client negotiates structured replies then reads are returned as a
+series of chunks covering the requested range, with any chunks that are
+entirely zero sent as holes rather than data.
+*/
+package nbd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+
+	"go4.org/readerutil"
+)
+
+const (
+	nbdMagic       uint64 = 0x4e42444d41474943 // "NBDMAGIC"
+	optMagic       uint64 = 0x49484156454f5054 // "IHAVEOPT"
+	optReplyMagic  uint64 = 0x0003e889045565a9
+	requestMagic   uint32 = 0x25609513
+	simpleMagic    uint32 = 0x67446698
+	structureMagic uint32 = 0x668e33ef
+
+	flagFixedNewstyle uint16 = 1 << 0
+	flagNoZeroes      uint16 = 1 << 1
+
+	flagHasFlags     uint16 = 1 << 0
+	flagReadOnly     uint16 = 1 << 1
+	flagSendDF       uint16 = 1 << 7
+	flagCanMultiConn uint16 = 1 << 8
+
+	optExportName      uint32 = 1
+	optAbort           uint32 = 2
+	optList            uint32 = 3
+	optInfo            uint32 = 6
+	optGo              uint32 = 7
+	optStructuredReply uint32 = 8
+
+	repAck        uint32 = 1
+	repServer     uint32 = 2
+	repInfo       uint32 = 3
+	repErrUnsup   uint32 = 1<<31 + 1
+	repErrInvalid uint32 = 1<<31 + 3
+	repErrUnknown uint32 = 1<<31 + 6
+
+	infoExport    uint16 = 0
+	infoBlockSize uint16 = 3
+
+	cmdRead        uint16 = 0
+	cmdWrite       uint16 = 1
+	cmdDisc        uint16 = 2
+	cmdFlush       uint16 = 3
+	cmdTrim        uint16 = 4
+	cmdWriteZeroes uint16 = 6
+
+	cmdFlagDF uint16 = 1 << 2
+
+	replyFlagDone uint16 = 1 << 0
+
+	replyTypeNone       uint16 = 0
+	replyTypeOffsetData uint16 = 1
+	replyTypeOffsetHole uint16 = 2
+	replyTypeError      uint16 = 1<<15 + 1
+
+	errPerm  uint32 = 1
+	errIO    uint32 = 5
+	errInval uint32 = 22
+
+	maxOptionLength  = 4096
+	maxRequestLength = 32 << 20
+	chunkSize        = 64 << 10
+	preferredBlock   = 0x8000
+)
+
+// ErrUnknownExport is returned if the client requests an export that does
+// not exist.
+var ErrUnknownExport = errors.New("nbd: unknown export")
+
+// Server is a read-only NBD server.
+type Server struct {
+	// Exports maps export names to the data served. If a client doesn't
+	// request a specific export and there is no export with an empty name
+	// then the first export, sorted by name, is used.
+	Exports map[string]readerutil.SizeReaderAt
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+}
+
+type conn struct {
+	s          *Server
+	c          net.Conn
+	br         *bufio.Reader
+	bw         *bufio.Writer
+	noZeroes   bool
+	structured bool
+	export     readerutil.SizeReaderAt
+}
+
+type request struct {
+	Magic  uint32
+	Flags  uint16
+	Type   uint16
+	Handle uint64
+	Offset uint64
+	Length uint32
+}
+
+// Serve accepts connections on l, serving each one in its own goroutine.
+// It only returns when l returns an error, such as when Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			_ = s.ServeConn(c)
+		}()
+	}
+}
+
+// Close closes any listeners passed to Serve.
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l := range s.listeners {
+		if e := l.Close(); err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// ServeConn performs the handshake and then serves requests on c until the
+// client disconnects. c is always closed before returning.
+func (s *Server) ServeConn(c net.Conn) error {
+	defer c.Close()
+
+	nc := &conn{
+		s:  s,
+		c:  c,
+		br: bufio.NewReader(c),
+		bw: bufio.NewWriter(c),
+	}
+
+	ok, err := nc.handshake()
+	if err != nil || !ok {
+		return err
+	}
+
+	return nc.transmission()
+}
+
+func (s *Server) lookup(name string) (readerutil.SizeReaderAt, bool) {
+	if ra, ok := s.Exports[name]; ok || name != "" {
+		return ra, ok
+	}
+
+	names := s.names()
+	if len(names) == 0 {
+		return nil, false
+	}
+
+	return s.Exports[names[0]], true
+}
+
+func (s *Server) names() []string {
+	names := make([]string, 0, len(s.Exports))
+	for name := range s.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *conn) transmissionFlags() uint16 {
+	flags := flagHasFlags | flagReadOnly | flagCanMultiConn
+	if c.structured {
+		flags |= flagSendDF
+	}
+	return flags
+}
+
+// handshake negotiates options with the client, returning true if the
+// client selected an export.
+func (c *conn) handshake() (bool, error) {
+	if err := binary.Write(c.bw, binary.BigEndian, struct {
+		Magic    uint64
+		OptMagic uint64
+		Flags    uint16
+	}{nbdMagic, optMagic, flagFixedNewstyle | flagNoZeroes}); err != nil {
+		return false, err
+	}
+	if err := c.bw.Flush(); err != nil {
+		return false, err
+	}
+
+	var flags uint32
+	if err := binary.Read(c.br, binary.BigEndian, &flags); err != nil {
+		return false, err
+	}
+	if flags&^uint32(flagFixedNewstyle|flagNoZeroes) != 0 || flags&uint32(flagFixedNewstyle) == 0 {
+		return false, errors.New("nbd: unsupported client flags")
+	}
+	c.noZeroes = flags&uint32(flagNoZeroes) != 0
+
+	for {
+		opt := struct {
+			Magic  uint64
+			Option uint32
+			Length uint32
+		}{}
+		if err := binary.Read(c.br, binary.BigEndian, &opt); err != nil {
+			return false, err
+		}
+		if opt.Magic != optMagic {
+			return false, errors.New("nbd: bad option magic")
+		}
+		if opt.Length > maxOptionLength {
+			return false, errors.New("nbd: option too long")
+		}
+		data := make([]byte, opt.Length)
+		if _, err := io.ReadFull(c.br, data); err != nil {
+			return false, err
+		}
+
+		var err error
+		switch opt.Option {
+		case optExportName:
+			var ok bool
+			if c.export, ok = c.s.lookup(string(data)); !ok {
+				return false, ErrUnknownExport
+			}
+			if err = binary.Write(c.bw, binary.BigEndian, struct {
+				Size  uint64
+				Flags uint16
+			}{uint64(c.export.Size()), c.transmissionFlags()}); err != nil {
+				return false, err
+			}
+			if !c.noZeroes {
+				if _, err = c.bw.Write(make([]byte, 124)); err != nil {
+					return false, err
+				}
+			}
+			return true, c.bw.Flush()
+		case optAbort:
+			if err = c.optionReply(opt.Option, repAck, nil); err != nil {
+				return false, err
+			}
+			return false, c.bw.Flush()
+		case optList:
+			err = c.list(opt.Option, data)
+		case optStructuredReply:
+			if len(data) != 0 {
+				err = c.optionReply(opt.Option, repErrInvalid, nil)
+				break
+			}
+			c.structured = true
+			err = c.optionReply(opt.Option, repAck, nil)
+		case optInfo, optGo:
+			var ok bool
+			if ok, err = c.info(opt.Option, data); err == nil && ok && opt.Option == optGo {
+				return true, c.bw.Flush()
+			}
+		default:
+			err = c.optionReply(opt.Option, repErrUnsup, nil)
+		}
+		if err != nil {
+			return false, err
+		}
+		if err = c.bw.Flush(); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (c *conn) optionReply(option, reply uint32, data []byte) error {
+	if err := binary.Write(c.bw, binary.BigEndian, struct {
+		Magic  uint64
+		Option uint32
+		Reply  uint32
+		Length uint32
+	}{optReplyMagic, option, reply, uint32(len(data))}); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(data)
+	return err
+}
+
+func (c *conn) list(option uint32, data []byte) error {
+	if len(data) != 0 {
+		return c.optionReply(option, repErrInvalid, nil)
+	}
+
+	for _, name := range c.s.names() {
+		b := make([]byte, 4+len(name))
+		binary.BigEndian.PutUint32(b, uint32(len(name)))
+		copy(b[4:], name)
+		if err := c.optionReply(option, repServer, b); err != nil {
+			return err
+		}
+	}
+
+	return c.optionReply(option, repAck, nil)
+}
+
+// info handles both NBD_OPT_INFO and NBD_OPT_GO, returning true if the
+// export was found.
+func (c *conn) info(option uint32, data []byte) (bool, error) {
+	if len(data) < 4 {
+		return false, c.optionReply(option, repErrInvalid, nil)
+	}
+	n := int(binary.BigEndian.Uint32(data))
+	if len(data) < 4+n+2 {
+		return false, c.optionReply(option, repErrInvalid, nil)
+	}
+	name := string(data[4 : 4+n])
+	requests := int(binary.BigEndian.Uint16(data[4+n:]))
+	if len(data) != 4+n+2+requests*2 {
+		return false, c.optionReply(option, repErrInvalid, nil)
+	}
+
+	export, ok := c.s.lookup(name)
+	if !ok {
+		return false, c.optionReply(option, repErrUnknown, []byte(ErrUnknownExport.Error()))
+	}
+
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, infoExport)
+	binary.BigEndian.PutUint64(b[2:], uint64(export.Size()))
+	binary.BigEndian.PutUint16(b[10:], c.transmissionFlags())
+	if err := c.optionReply(option, repInfo, b); err != nil {
+		return false, err
+	}
+
+	for i := 0; i < requests; i++ {
+		if binary.BigEndian.Uint16(data[4+n+2+i*2:]) != infoBlockSize {
+			continue
+		}
+		b = make([]byte, 14)
+		binary.BigEndian.PutUint16(b, infoBlockSize)
+		binary.BigEndian.PutUint32(b[2:], 1)
+		binary.BigEndian.PutUint32(b[6:], preferredBlock)
+		binary.BigEndian.PutUint32(b[10:], maxRequestLength)
+		if err := c.optionReply(option, repInfo, b); err != nil {
+			return false, err
+		}
+	}
+
+	if err := c.optionReply(option, repAck, nil); err != nil {
+		return false, err
+	}
+
+	if option == optGo {
+		c.export = export
+	}
+
+	return true, nil
+}
+
+func (c *conn) transmission() error {
+	for {
+		req := request{}
+		if err := binary.Read(c.br, binary.BigEndian, &req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Magic != requestMagic {
+			return errors.New("nbd: bad request magic")
+		}
+
+		var err error
+		switch req.Type {
+		case cmdRead:
+			err = c.read(req)
+		case cmdWrite:
+			// Discard the payload before refusing
+			if _, err = io.CopyN(ioutil.Discard, c.br, int64(req.Length)); err != nil {
+				return err
+			}
+			err = c.reply(req, errPerm)
+		case cmdTrim, cmdWriteZeroes:
+			err = c.reply(req, errPerm)
+		case cmdFlush:
+			err = c.reply(req, 0)
+		case cmdDisc:
+			return c.bw.Flush()
+		default:
+			err = c.reply(req, errInval)
+		}
+		if err != nil {
+			return err
+		}
+		if err = c.bw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// reply sends a reply with no payload, either a successful reply or an error.
+func (c *conn) reply(req request, errno uint32) error {
+	if !c.structured {
+		return binary.Write(c.bw, binary.BigEndian, struct {
+			Magic  uint32
+			Error  uint32
+			Handle uint64
+		}{simpleMagic, errno, req.Handle})
+	}
+
+	if errno == 0 {
+		return c.chunk(req, replyFlagDone, replyTypeNone, nil)
+	}
+
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint32(b, errno)
+	return c.chunk(req, replyFlagDone, replyTypeError, b)
+}
+
+func (c *conn) chunk(req request, flags, typ uint16, payload ...[]byte) error {
+	length := 0
+	for _, p := range payload {
+		length += len(p)
+	}
+	if err := binary.Write(c.bw, binary.BigEndian, struct {
+		Magic  uint32
+		Flags  uint16
+		Type   uint16
+		Handle uint64
+		Length uint32
+	}{structureMagic, flags, typ, req.Handle, uint32(length)}); err != nil {
+		return err
+	}
+	for _, p := range payload {
+		if _, err := c.bw.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *conn) read(req request) error {
+	if req.Length > maxRequestLength || req.Offset+uint64(req.Length) > uint64(c.export.Size()) || req.Offset+uint64(req.Length) < req.Offset {
+		return c.reply(req, errInval)
+	}
+
+	b := make([]byte, req.Length)
+	if _, err := c.export.ReadAt(b, int64(req.Offset)); err != nil && err != io.EOF {
+		return c.reply(req, errIO)
+	}
+
+	if !c.structured {
+		if err := c.reply(req, 0); err != nil {
+			return err
+		}
+		_, err := c.bw.Write(b)
+		return err
+	}
+
+	if len(b) == 0 {
+		return c.reply(req, 0)
+	}
+
+	// The client wants the data in one chunk
+	size := chunkSize
+	if req.Flags&cmdFlagDF != 0 {
+		size = len(b)
+	}
+
+	for off := 0; off < len(b); off += size {
+		end := off + size
+		if end > len(b) {
+			end = len(b)
+		}
+
+		var flags uint16
+		if end == len(b) {
+			flags = replyFlagDone
+		}
+
+		hdr := make([]byte, 12)
+		binary.BigEndian.PutUint64(hdr, req.Offset+uint64(off))
+
+		var err error
+		if req.Flags&cmdFlagDF == 0 && isZero(b[off:end]) {
+			binary.BigEndian.PutUint32(hdr[8:], uint32(end-off))
+			err = c.chunk(req, flags, replyTypeOffsetHole, hdr)
+		} else {
+			err = c.chunk(req, flags, replyTypeOffsetData, hdr[:8], b[off:end])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
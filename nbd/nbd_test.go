@@ -0,0 +1,232 @@
+package nbd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	"go4.org/readerutil"
+)
+
+type testClient struct {
+	t *testing.T
+	c net.Conn
+}
+
+func newTestClient(t *testing.T, exports map[string]readerutil.SizeReaderAt) *testClient {
+	t.Helper()
+
+	sc, cc := net.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- (&Server{Exports: exports}).ServeConn(sc)
+	}()
+	t.Cleanup(func() {
+		cc.Close()
+		if err := <-done; err != nil && err != io.ErrClosedPipe {
+			t.Errorf("server: %v", err)
+		}
+	})
+
+	c := &testClient{t, cc}
+
+	hello := struct {
+		Magic    uint64
+		OptMagic uint64
+		Flags    uint16
+	}{}
+	c.read(&hello)
+	if hello.Magic != nbdMagic || hello.OptMagic != optMagic || hello.Flags&flagFixedNewstyle == 0 {
+		t.Fatalf("bad server greeting %+v", hello)
+	}
+	c.write(uint32(flagFixedNewstyle | flagNoZeroes))
+
+	return c
+}
+
+func (c *testClient) read(v interface{}) {
+	c.t.Helper()
+	if err := binary.Read(c.c, binary.BigEndian, v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) write(v interface{}) {
+	c.t.Helper()
+	if err := binary.Write(c.c, binary.BigEndian, v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) option(option uint32, data []byte) {
+	c.t.Helper()
+	c.write(struct {
+		Magic  uint64
+		Option uint32
+		Length uint32
+	}{optMagic, option, uint32(len(data))})
+	if len(data) > 0 {
+		c.write(data)
+	}
+}
+
+// optionReply reads an option reply and its payload.
+func (c *testClient) optionReply(option uint32) (uint32, []byte) {
+	c.t.Helper()
+	rep := struct {
+		Magic  uint64
+		Option uint32
+		Reply  uint32
+		Length uint32
+	}{}
+	c.read(&rep)
+	if rep.Magic != optReplyMagic || rep.Option != option {
+		c.t.Fatalf("bad option reply %+v", rep)
+	}
+	b := make([]byte, rep.Length)
+	c.read(b)
+	return rep.Reply, b
+}
+
+func (c *testClient) request(typ, flags uint16, handle, off uint64, n uint32) {
+	c.t.Helper()
+	c.write(request{requestMagic, flags, typ, handle, off, n})
+}
+
+func testExport(b []byte) readerutil.SizeReaderAt {
+	return io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b)))
+}
+
+func TestSimpleRead(t *testing.T) {
+	image := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(image)
+
+	c := newTestClient(t, map[string]readerutil.SizeReaderAt{"image": testExport(image)})
+
+	c.option(optExportName, []byte("image"))
+	export := struct {
+		Size  uint64
+		Flags uint16
+	}{}
+	c.read(&export)
+	if export.Size != uint64(len(image)) || export.Flags&flagReadOnly == 0 {
+		t.Fatalf("bad export %+v", export)
+	}
+
+	tests := []struct {
+		name  string
+		off   uint64
+		n     uint32
+		errno uint32
+	}{
+		{"start", 0, 512, 0},
+		{"middle", 1000, 24, 0},
+		{"end", 4000, 96, 0},
+		{"past end", 4000, 97, errInval},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.request(cmdRead, 0, uint64(i), tt.off, tt.n)
+
+			rep := struct {
+				Magic  uint32
+				Error  uint32
+				Handle uint64
+			}{}
+			c.read(&rep)
+			if rep.Magic != simpleMagic || rep.Handle != uint64(i) || rep.Error != tt.errno {
+				t.Fatalf("bad reply %+v", rep)
+			}
+			if tt.errno != 0 {
+				return
+			}
+
+			b := make([]byte, tt.n)
+			c.read(b)
+			if !bytes.Equal(b, image[tt.off:tt.off+uint64(tt.n)]) {
+				t.Error("data does not match")
+			}
+		})
+	}
+
+	c.request(cmdDisc, 0, 0, 0, 0)
+}
+
+func TestStructuredRead(t *testing.T) {
+	// One chunk of zeroes followed by a chunk of data
+	image := make([]byte, 2*chunkSize)
+	rand.New(rand.NewSource(1)).Read(image[chunkSize:])
+
+	c := newTestClient(t, map[string]readerutil.SizeReaderAt{"image": testExport(image)})
+
+	c.option(optStructuredReply, nil)
+	if rep, _ := c.optionReply(optStructuredReply); rep != repAck {
+		t.Fatalf("got reply %#x, want %#x", rep, repAck)
+	}
+
+	// Request the default export
+	c.option(optGo, []byte{0, 0, 0, 0, 0, 0})
+	rep, b := c.optionReply(optGo)
+	if rep != repInfo || len(b) != 12 || binary.BigEndian.Uint64(b[2:]) != uint64(len(image)) {
+		t.Fatalf("bad info reply %#x %x", rep, b)
+	}
+	if rep, _ = c.optionReply(optGo); rep != repAck {
+		t.Fatalf("got reply %#x, want %#x", rep, repAck)
+	}
+
+	c.request(cmdRead, 0, 1, 0, uint32(len(image)))
+
+	type header struct {
+		Magic  uint32
+		Flags  uint16
+		Type   uint16
+		Handle uint64
+		Length uint32
+	}
+
+	hole := header{}
+	c.read(&hole)
+	if hole.Magic != structureMagic || hole.Type != replyTypeOffsetHole || hole.Flags&replyFlagDone != 0 || hole.Length != 12 {
+		t.Fatalf("bad hole chunk %+v", hole)
+	}
+	b = make([]byte, hole.Length)
+	c.read(b)
+	if off, n := binary.BigEndian.Uint64(b), binary.BigEndian.Uint32(b[8:]); off != 0 || n != chunkSize {
+		t.Fatalf("got hole at %d of %d bytes", off, n)
+	}
+
+	data := header{}
+	c.read(&data)
+	if data.Magic != structureMagic || data.Type != replyTypeOffsetData || data.Flags&replyFlagDone == 0 || data.Length != 8+chunkSize {
+		t.Fatalf("bad data chunk %+v", data)
+	}
+	b = make([]byte, data.Length)
+	c.read(b)
+	if off := binary.BigEndian.Uint64(b); off != chunkSize {
+		t.Fatalf("got data at %d", off)
+	}
+	if !bytes.Equal(b[8:], image[chunkSize:]) {
+		t.Error("data does not match")
+	}
+
+	c.request(cmdDisc, 0, 0, 0, 0)
+}
+
+func TestUnknownExport(t *testing.T) {
+	c := newTestClient(t, map[string]readerutil.SizeReaderAt{"image": testExport(make([]byte, 512))})
+
+	c.option(optInfo, append([]byte{0, 0, 0, 5}, "other\x00\x00"...))
+	if rep, _ := c.optionReply(optInfo); rep != repErrUnknown {
+		t.Fatalf("got reply %#x, want %#x", rep, repErrUnknown)
+	}
+
+	c.option(optAbort, nil)
+	if rep, _ := c.optionReply(optAbort); rep != repAck {
+		t.Fatalf("got reply %#x, want %#x", rep, repAck)
+	}
+}
@@ -0,0 +1,120 @@
+package wud
+
+import (
+	"bytes"
+	"io"
+
+	"go4.org/readerutil"
+)
+
+type paddedReader struct {
+	r    io.ReaderAt
+	n    int64
+	size int64
+}
+
+func (p *paddedReader) ReadAt(b []byte, off int64) (n int, err error) {
+	if off >= p.size {
+		return 0, io.EOF
+	}
+	if max := p.size - off; int64(len(b)) > max {
+		b = b[:max]
+		err = io.EOF
+	}
+
+	// Read what we can from the underlying reader
+	if off < p.n {
+		m := len(b)
+		if max := p.n - off; int64(m) > max {
+			m = int(max)
+		}
+		if k, e := p.r.ReadAt(b[:m], off); k < m {
+			if e == nil || e == io.EOF {
+				e = io.ErrUnexpectedEOF
+			}
+			return k, e
+		}
+		n, b = m, b[m:]
+	}
+
+	// Anything beyond that is zeros
+	for i := range b {
+		b[i] = 0
+	}
+
+	return n + len(b), err
+}
+
+func (p *paddedReader) Size() int64 {
+	return p.size
+}
+
+// NewPaddedReader returns a Reader that reads from r followed by zeros up to
+// size. This allows a trimmed image, with the trailing unused space removed,
+// to be read as if it was full size.
+func NewPaddedReader(r readerutil.SizeReaderAt, size int64) Reader {
+	if size < r.Size() {
+		size = r.Size()
+	}
+	return io.NewSectionReader(&paddedReader{r, r.Size(), size}, 0, size)
+}
+
+// PadImage returns the disc image read from r padded with zeros up to
+// UncompressedSize if it has been trimmed, or ErrWrongSize if it is too small
+// to even hold the table of contents.
+func PadImage(r readerutil.SizeReaderAt) (Reader, error) {
+	switch size := r.Size(); {
+	case size < 4*int64(SectorSize):
+		return nil, ErrWrongSize
+	case size < int64(UncompressedSize):
+		return NewPaddedReader(r, int64(UncompressedSize)), nil
+	default:
+		return io.NewSectionReader(r, 0, size), nil
+	}
+}
+
+// TrimmedSize returns the size of the disc image read from r, which is size
+// bytes long, with any trailing sectors that are entirely zeros removed.
+func TrimmedSize(r io.ReaderAt, size int64) (int64, error) {
+	const chunkSectors = 64
+
+	sectorSize := int64(SectorSize)
+	zero := make([]byte, sectorSize)
+	b := make([]byte, chunkSectors*sectorSize)
+
+	// Work backwards a chunk at a time from the last whole or partial sector
+	sectors := (size + sectorSize - 1) / sectorSize
+	for sectors > 0 {
+		n := int64(chunkSectors)
+		if n > sectors {
+			n = sectors
+		}
+		start := (sectors - n) * sectorSize
+		end := sectors * sectorSize
+		if end > size {
+			end = size
+		}
+		chunk := b[:end-start]
+
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := n - 1; i >= 0; i-- {
+			sector := chunk[i*sectorSize:]
+			if int64(len(sector)) > sectorSize {
+				sector = sector[:sectorSize]
+			}
+			if !bytes.Equal(sector, zero[:len(sector)]) {
+				if end = start + (i+1)*sectorSize; end > size {
+					end = size
+				}
+				return end, nil
+			}
+		}
+
+		sectors -= n
+	}
+
+	return 0, nil
+}
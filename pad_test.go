@@ -0,0 +1,123 @@
+package wud
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPaddedReaderReadAt(t *testing.T) {
+	data := []byte("abcdefgh")
+	r := NewPaddedReader(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), 16)
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want []byte
+		err  error
+	}{
+		{"data", 0, 4, []byte("abcd"), nil},
+		{"data and padding", 6, 4, []byte("gh\x00\x00"), nil},
+		{"padding", 10, 4, make([]byte, 4), nil},
+		{"past end", 12, 8, make([]byte, 4), io.EOF},
+		{"at end", 16, 1, []byte{}, io.EOF},
+		{"everything", 0, 16, append([]byte("abcdefgh"), make([]byte, 8)...), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Padding must be zeroed even if the buffer isn't
+			b := bytes.Repeat([]byte{0xff}, tt.n)
+			n, err := r.ReadAt(b, tt.off)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !bytes.Equal(b[:n], tt.want) {
+				t.Errorf("got %q, want %q", b[:n], tt.want)
+			}
+		})
+	}
+
+	if r.Size() != 16 {
+		t.Errorf("got size %d, want 16", r.Size())
+	}
+}
+
+func TestPaddedReaderShortRead(t *testing.T) {
+	// The underlying reader claims to be bigger than it is
+	r := NewPaddedReader(io.NewSectionReader(bytes.NewReader([]byte("abcd")), 0, 8), 16)
+
+	if _, err := r.ReadAt(make([]byte, 8), 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestTrimmedSize(t *testing.T) {
+	sectorSize := int(SectorSize)
+
+	image := func(size int, nonzero ...int) []byte {
+		b := make([]byte, size)
+		for _, off := range nonzero {
+			b[off] = 1
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		want int64
+	}{
+		{"empty", nil, 0},
+		{"all zeros", image(4 * sectorSize), 0},
+		{"first byte", image(4*sectorSize, 0), int64(sectorSize)},
+		{"last byte of sector", image(4*sectorSize, 2*sectorSize-1), 2 * int64(sectorSize)},
+		{"first byte of sector", image(4*sectorSize, 2*sectorSize), 3 * int64(sectorSize)},
+		{"last byte", image(4*sectorSize, 4*sectorSize-1), 4 * int64(sectorSize)},
+		{"partial sector", image(3*sectorSize+10, 3*sectorSize+5), 3*int64(sectorSize) + 10},
+		{"across chunks", image(100*sectorSize, 10, 70*sectorSize), 71 * int64(sectorSize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TrimmedSize(bytes.NewReader(tt.b), int64(len(tt.b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPadImage(t *testing.T) {
+	sectorSize := int64(SectorSize)
+
+	tests := []struct {
+		name string
+		size int64
+		want int64
+		err  error
+	}{
+		{"too small", 4*sectorSize - 1, 0, ErrWrongSize},
+		{"trimmed", 4 * sectorSize, int64(UncompressedSize), nil},
+		{"full size", int64(UncompressedSize), int64(UncompressedSize), nil},
+		{"oversized", int64(UncompressedSize) + sectorSize, int64(UncompressedSize) + sectorSize, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing is read so the size is all that matters
+			r, err := PadImage(io.NewSectionReader(bytes.NewReader(nil), 0, tt.size))
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && r.Size() != tt.want {
+				t.Errorf("got size %d, want %d", r.Size(), tt.want)
+			}
+		})
+	}
+}
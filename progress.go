@@ -0,0 +1,66 @@
+package wud
+
+import (
+	"context"
+	"io"
+)
+
+// Progress receives updates from long running operations.
+type Progress interface {
+	// Progress is called with the name of the file currently being
+	// written, the number of bytes written so far and the total number of
+	// bytes that will be written.
+	Progress(name string, written, total int64)
+}
+
+// ProgressFunc is an adapter to allow the use of ordinary functions as
+// Progress.
+type ProgressFunc func(name string, written, total int64)
+
+// Progress calls f(name, written, total).
+func (f ProgressFunc) Progress(name string, written, total int64) {
+	f(name, written, total)
+}
+
+type counter struct {
+	ctx      context.Context
+	progress Progress
+	name     string
+	written  int64
+	total    int64
+}
+
+type counterReader struct {
+	c *counter
+	r io.Reader
+}
+
+func (cr *counterReader) Read(p []byte) (int, error) {
+	if err := cr.c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := cr.r.Read(p)
+	cr.c.written += int64(n)
+
+	if cr.c.progress != nil {
+		cr.c.progress.Progress(cr.c.name, cr.c.written, cr.c.total)
+	}
+
+	return n, err
+}
+
+// reader returns an io.Reader that reads from r, reporting progress under
+// name and failing once ctx is done.
+func (c *counter) reader(name string, r io.Reader) io.Reader {
+	c.name = name
+	return &counterReader{c, r}
+}
+
+// CopyContext copies from src to dst like io.Copy but stops with the error
+// from ctx once it is done. If p is not nil it is called with name, the
+// number of bytes copied so far and total after each read from src.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader, name string, total int64, p Progress) (int64, error) {
+	c := &counter{ctx: ctx, progress: p, total: total}
+	return io.Copy(dst, c.reader(name, src))
+}
@@ -0,0 +1,278 @@
+/*
+Package remote implements reading of Nintendo Wii-U disc images stored on a
+web server or object store gateway. Reads are satisfied using HTTP Range
+requests for whole chunks of the image which are kept in a small cache and,
+when the image is read sequentially, the following chunk is requested ahead of
+time.
+*/
+package remote
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bodgit/wud"
+)
+
+const (
+	defaultChunkSize = 1 << 20
+	defaultChunks    = 64
+)
+
+var (
+	// ErrRangeNotSupported is returned if the server does not honour Range
+	// requests.
+	ErrRangeNotSupported = errors.New("remote: range requests not supported")
+	// ErrChanged is returned if the image changes on the server while it is
+	// being read.
+	ErrChanged = errors.New("remote: image changed")
+)
+
+type chunk struct {
+	done chan struct{}
+	b    []byte
+	err  error
+	e    *list.Element
+}
+
+type reader struct {
+	client    *http.Client
+	url       string
+	etag      string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	off       int64
+	limit     int64
+	chunkSize int64
+	chunks    int
+
+	mu    sync.Mutex
+	cache map[int64]*chunk
+	lru   *list.List
+	last  int64
+}
+
+// NewReader returns a new wud.ReadCloser that reads the disc image at url
+// using client, or http.DefaultClient if client is nil.
+func NewReader(client *http.Client, url string) (wud.ReadCloser, error) {
+	return NewReaderSize(client, url, defaultChunkSize, defaultChunks)
+}
+
+// NewReaderSize returns a new wud.ReadCloser that reads the disc image at url
+// using client, or http.DefaultClient if client is nil. The image is
+// requested in chunkSize byte chunks and up to chunks of them are cached.
+func NewReaderSize(client *http.Client, url string, chunkSize, chunks int) (wud.ReadCloser, error) {
+	if chunkSize <= 0 || chunks <= 0 {
+		return nil, errors.New("remote: bad chunk size")
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	r := &reader{
+		client:    client,
+		url:       url,
+		chunkSize: int64(chunkSize),
+		chunks:    chunks,
+		cache:     make(map[int64]*chunk),
+		lru:       list.New(),
+		last:      -1,
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	// Request the first byte to find the size and confirm the server
+	// supports Range requests
+	resp, err := r.get(0, 1)
+	if err != nil {
+		r.cancel()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	if !strings.HasPrefix(cr, "bytes ") || i < 0 {
+		r.cancel()
+		return nil, errors.New("remote: bad Content-Range")
+	}
+	if r.limit, err = strconv.ParseInt(cr[i+1:], 10, 64); err != nil {
+		r.cancel()
+		return nil, errors.New("remote: bad Content-Range")
+	}
+	r.etag = resp.Header.Get("ETag")
+
+	return r, nil
+}
+
+func (r *reader) get(off, n int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if r.etag != "" {
+		req.Header.Set("If-Range", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusOK:
+		err = ErrRangeNotSupported
+		if r.etag != "" {
+			err = ErrChanged
+		}
+	default:
+		err = fmt.Errorf("remote: %s", resp.Status)
+	}
+	resp.Body.Close()
+
+	return nil, err
+}
+
+func (r *reader) fetch(i int64, c *chunk) {
+	defer close(c.done)
+
+	off := i * r.chunkSize
+	n := r.chunkSize
+	if off+n > r.limit {
+		n = r.limit - off
+	}
+
+	resp, err := r.get(off, n)
+	if err != nil {
+		c.err = err
+		return
+	}
+	defer resp.Body.Close()
+
+	c.b = make([]byte, n)
+	if _, c.err = io.ReadFull(resp.Body, c.b); c.err != nil {
+		c.b = nil
+	}
+}
+
+// chunk returns the cached chunk i, starting a request for it if necessary.
+// The caller must hold r.mu.
+func (r *reader) chunk(i int64) *chunk {
+	if c, ok := r.cache[i]; ok {
+		r.lru.MoveToFront(c.e)
+		return c
+	}
+
+	c := &chunk{
+		done: make(chan struct{}),
+	}
+	c.e = r.lru.PushFront(i)
+	r.cache[i] = c
+
+	// Evict the least recently used chunk
+	if r.lru.Len() > r.chunks {
+		e := r.lru.Back()
+		r.lru.Remove(e)
+		delete(r.cache, e.Value.(int64))
+	}
+
+	go r.fetch(i, c)
+
+	return c
+}
+
+func (r *reader) readChunk(i int64) ([]byte, error) {
+	r.mu.Lock()
+	c := r.chunk(i)
+
+	// Read ahead if this looks like a sequential read
+	if i == r.last+1 && (i+1)*r.chunkSize < r.limit {
+		r.chunk(i + 1)
+	}
+	r.last = i
+	r.mu.Unlock()
+
+	<-c.done
+
+	if c.err != nil {
+		// Don't cache failures
+		r.mu.Lock()
+		if r.cache[i] == c {
+			r.lru.Remove(c.e)
+			delete(r.cache, i)
+		}
+		r.mu.Unlock()
+	}
+
+	return c.b, c.err
+}
+
+func (r *reader) Size() int64 {
+	return r.limit
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.off >= r.limit {
+		return 0, io.EOF
+	}
+	if max := r.limit - r.off; int64(len(p)) > max {
+		p = p[0:max]
+	}
+	n, err = r.ReadAt(p, r.off)
+	r.off += int64(n)
+	return
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= r.limit {
+		return 0, io.EOF
+	}
+	if max := r.limit - off; int64(len(p)) > max {
+		p = p[0:max]
+		err = io.EOF
+	}
+	for len(p) > 0 {
+		b, e := r.readChunk(off / r.chunkSize)
+		if e != nil {
+			return n, e
+		}
+		m := copy(p, b[off%r.chunkSize:])
+		p = p[m:]
+		off += int64(m)
+		n += m
+	}
+	return
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	default:
+		return 0, errors.New("remote: invalid whence")
+	case io.SeekStart:
+		break
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.limit
+	}
+	if offset < 0 {
+		return 0, errors.New("remote: invalid offset")
+	}
+	r.off = offset
+	return offset, nil
+}
@@ -0,0 +1,127 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves an image that can be replaced while it is being read.
+type testServer struct {
+	mu   sync.Mutex
+	b    []byte
+	etag string
+}
+
+func (s *testServer) set(b []byte, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b, s.etag = b, etag
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, etag := s.b, s.etag
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "image.wud", time.Time{}, bytes.NewReader(b))
+}
+
+func testImage(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestReaderAt(t *testing.T) {
+	image := testImage(100)
+
+	s := new(testServer)
+	s.set(image, `"1"`)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	r, err := NewReaderSize(ts.Client(), ts.URL, 16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Size() != int64(len(image)) {
+		t.Fatalf("got size %d, want %d", r.Size(), len(image))
+	}
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want int
+		err  error
+	}{
+		{"first chunk", 0, 16, 16, nil},
+		{"within chunk", 20, 4, 4, nil},
+		{"across chunks", 10, 40, 40, nil},
+		{"evicted chunk", 0, 8, 8, nil},
+		{"last byte", 99, 1, 1, nil},
+		{"past end", 90, 20, 10, io.EOF},
+		{"beyond end", 100, 1, 0, io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, tt.n)
+			n, err := r.ReadAt(b, tt.off)
+			if n != tt.want || err != tt.err {
+				t.Fatalf("got %d, %v, want %d, %v", n, err, tt.want, tt.err)
+			}
+			if !bytes.Equal(b[:n], image[tt.off:tt.off+int64(n)]) {
+				t.Error("data does not match")
+			}
+		})
+	}
+}
+
+func TestReaderChanged(t *testing.T) {
+	s := new(testServer)
+	s.set(testImage(100), `"1"`)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	r, err := NewReaderSize(ts.Client(), ts.URL, 16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b := make([]byte, 16)
+	if _, err = r.ReadAt(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The If-Range no longer matches so the whole image is returned
+	s.set(testImage(101), `"2"`)
+
+	if _, err = r.ReadAt(b, 64); !errors.Is(err, ErrChanged) {
+		t.Errorf("got %v, want %v", err, ErrChanged)
+	}
+}
+
+func TestReaderRangeNotSupported(t *testing.T) {
+	image := testImage(100)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(image)
+	}))
+	defer ts.Close()
+
+	if _, err := NewReader(ts.Client(), ts.URL); !errors.Is(err, ErrRangeNotSupported) {
+		t.Errorf("got %v, want %v", err, ErrRangeNotSupported)
+	}
+}
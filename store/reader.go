@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/bodgit/wud"
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"go4.org/readerutil"
+)
+
+type reader struct {
+	packs      map[uint32]afero.File
+	table      []location
+	off        int64
+	limit      int64
+	sectorSize int64
+}
+
+// Open returns a wud.ReadCloser for the disc image described by the manifest
+// name.
+func (s *Store) Open(name string) (wud.ReadCloser, error) {
+	path, err := s.manifestPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := afero.ReadFile(fs, path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	br := bytes.NewReader(b)
+
+	h := manifestHeader{}
+	if err = binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.Magic != manifestMagic {
+		return nil, ErrBadMagic
+	}
+	if int64(h.SectorSize) != s.sectorSize {
+		return nil, errors.New("store: wrong sector size")
+	}
+
+	r := &reader{
+		packs:      make(map[uint32]afero.File),
+		limit:      int64(h.Size),
+		sectorSize: s.sectorSize,
+	}
+	r.table = make([]location, (r.limit+r.sectorSize-1)/r.sectorSize)
+
+	for i := range r.table {
+		var d digest
+		if _, err = io.ReadFull(br, d[:]); err != nil {
+			return nil, err
+		}
+
+		loc, ok := s.index[d]
+		if !ok {
+			return nil, multierror.Append(errors.New("store: missing sector"), r.Close())
+		}
+		r.table[i] = loc
+
+		if _, ok := r.packs[loc.pack]; ok {
+			continue
+		}
+		f, err := fs.Open(s.packPath(loc.pack, packExt))
+		if err != nil {
+			return nil, multierror.Append(err, r.Close())
+		}
+		r.packs[loc.pack] = f
+	}
+
+	return r, nil
+}
+
+func (r *reader) Size() int64 {
+	return r.limit
+}
+
+func (r *reader) Close() (err error) {
+	for _, f := range r.packs {
+		if e := f.Close(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return
+}
+
+func (r *reader) newSizeReaderAt(l, off int64) readerutil.SizeReaderAt {
+	sr := []readerutil.SizeReaderAt{}
+	for l > 0 {
+		sectorOffset := off % r.sectorSize
+		loc := r.table[off/r.sectorSize]
+		limit := r.sectorSize - sectorOffset
+		if limit > l {
+			limit = l
+		}
+		sr = append(sr, io.NewSectionReader(r.packs[loc.pack], int64(loc.sector)*r.sectorSize+sectorOffset, limit))
+		l -= limit
+		off += limit
+	}
+	return readerutil.NewMultiReaderAt(sr...)
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.off >= r.limit {
+		return 0, io.EOF
+	}
+	if max := r.limit - r.off; int64(len(p)) > max {
+		p = p[0:max]
+	}
+	n, err = r.newSizeReaderAt(int64(len(p)), r.off).ReadAt(p, 0)
+	r.off += int64(n)
+	return
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= r.limit {
+		return 0, io.EOF
+	}
+	if max := r.limit - off; int64(len(p)) > max {
+		p = p[0:max]
+		n, err = r.newSizeReaderAt(int64(len(p)), off).ReadAt(p, 0)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return r.newSizeReaderAt(int64(len(p)), off).ReadAt(p, 0)
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	default:
+		return 0, errors.New("store: invalid whence")
+	case io.SeekStart:
+		break
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.limit
+	}
+	if offset < 0 {
+		return 0, errors.New("store: invalid offset")
+	}
+	r.off = offset
+	return offset, nil
+}
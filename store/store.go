@@ -0,0 +1,349 @@
+/*
+Package store implements a content-addressed sector store that can be shared
+between many Nintendo Wii-U disc images. Each unique sector is stored once,
+keyed by its SHA-1 digest, in a series of append-only pack files. Each
+imported disc image is described by a manifest listing the digest of every
+sector in the image.
+
+The layout of a store directory is:
+
+	store                   header recording the sector size
+	packs/00000000.pack     raw sectors
+	packs/00000000.idx      SHA-1 digest of each sector in the pack
+	manifests/NAME.manifest SHA-1 digest of each sector in the image
+
+A Store is not safe for concurrent use, nor should more than one process
+import into the same directory at the same time.
+*/
+package store
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	headerFile    = "store"
+	packDir       = "packs"
+	manifestDir   = "manifests"
+	packExt       = ".pack"
+	indexExt      = ".idx"
+	manifestExt   = ".manifest"
+	maxPackSize   = 1 << 30
+	storeMagic    = 0x52545357 // "WSTR"
+	manifestMagic = 0x464d5357 // "WSMF"
+)
+
+var fs = afero.NewOsFs()
+
+var (
+	// ErrNotFound is returned if the requested manifest does not exist.
+	ErrNotFound = errors.New("store: manifest not found")
+	// ErrBadMagic is returned if a store or manifest file does not start
+	// with the correct magic.
+	ErrBadMagic = errors.New("store: bad magic")
+)
+
+type storeHeader struct {
+	Magic      uint32
+	SectorSize uint32
+}
+
+type manifestHeader struct {
+	Magic      uint32
+	SectorSize uint32
+	Size       uint64
+}
+
+type digest [sha1.Size]byte
+
+type location struct {
+	pack   uint32
+	sector uint32
+}
+
+// Store represents a content-addressed sector store.
+type Store struct {
+	dir        string
+	sectorSize int64
+	index      map[digest]location
+	packs      []uint32 // number of sectors in each pack
+}
+
+// Create creates a new empty store in dir using sectorSize sized sectors.
+func Create(dir string, sectorSize uint32) (*Store, error) {
+	if sectorSize == 0 {
+		return nil, errors.New("store: bad sector size")
+	}
+
+	for _, d := range []string{packDir, manifestDir} {
+		if err := fs.MkdirAll(filepath.Join(dir, d), os.ModePerm|os.ModeDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := fs.Stat(filepath.Join(dir, headerFile)); err == nil {
+		return nil, errors.New("store: already exists")
+	}
+
+	f, err := fs.Create(filepath.Join(dir, headerFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := storeHeader{
+		Magic:      storeMagic,
+		SectorSize: sectorSize,
+	}
+	if err = binary.Write(f, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+
+	return Open(dir)
+}
+
+// Open opens an existing store in dir.
+func Open(dir string) (*Store, error) {
+	b, err := afero.ReadFile(fs, filepath.Join(dir, headerFile))
+	if err != nil {
+		return nil, err
+	}
+
+	h := storeHeader{}
+	if err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.Magic != storeMagic {
+		return nil, ErrBadMagic
+	}
+
+	s := &Store{
+		dir:        dir,
+		sectorSize: int64(h.SectorSize),
+		index:      make(map[digest]location),
+	}
+
+	// Packs are numbered sequentially from zero
+	for i := uint32(0); true; i++ {
+		b, err := afero.ReadFile(fs, s.packPath(i, indexExt))
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+
+		n := uint32(len(b) / sha1.Size)
+		for j := uint32(0); j < n; j++ {
+			var d digest
+			copy(d[:], b[j*sha1.Size:])
+			if _, ok := s.index[d]; !ok {
+				s.index[d] = location{pack: i, sector: j}
+			}
+		}
+		s.packs = append(s.packs, n)
+	}
+
+	return s, nil
+}
+
+// SectorSize returns the size of each sector in the store.
+func (s *Store) SectorSize() uint32 {
+	return uint32(s.sectorSize)
+}
+
+func (s *Store) packPath(pack uint32, ext string) string {
+	return filepath.Join(s.dir, packDir, fmt.Sprintf("%08d%s", pack, ext))
+}
+
+func (s *Store) manifestPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", errors.New("store: bad manifest name")
+	}
+	return filepath.Join(s.dir, manifestDir, name+manifestExt), nil
+}
+
+// Manifests returns the sorted names of all of the manifests in the store.
+func (s *Store) Manifests() ([]string, error) {
+	fi, err := afero.ReadDir(fs, filepath.Join(s.dir, manifestDir))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, f := range fi {
+		if f.Mode().IsRegular() && filepath.Ext(f.Name()) == manifestExt {
+			names = append(names, strings.TrimSuffix(f.Name(), manifestExt))
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+type packWriter struct {
+	pack afero.File
+	idx  afero.File
+	n    uint32
+}
+
+func (s *Store) openPack(i uint32) (*packWriter, error) {
+	pw := new(packWriter)
+
+	var err error
+	if pw.idx, err = fs.OpenFile(s.packPath(i, indexExt), os.O_RDWR|os.O_CREATE, 0666); err != nil {
+		return nil, err
+	}
+	if pw.pack, err = fs.OpenFile(s.packPath(i, packExt), os.O_RDWR|os.O_CREATE, 0666); err != nil {
+		pw.idx.Close()
+		return nil, err
+	}
+
+	// The index is authoritative, anything in the pack beyond what the
+	// index describes is from an interrupted import and is overwritten
+	pw.n = s.packs[i]
+	if _, err = pw.idx.Seek(int64(pw.n)*sha1.Size, io.SeekStart); err != nil {
+		pw.close()
+		return nil, err
+	}
+	if _, err = pw.pack.Seek(int64(pw.n)*s.sectorSize, io.SeekStart); err != nil {
+		pw.close()
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func (pw *packWriter) close() error {
+	err := pw.pack.Close()
+	if e := pw.idx.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Import reads a disc image of size bytes from r and adds it to the store
+// as name, replacing any existing manifest with the same name. Only sectors
+// not already present in the store are written.
+func (s *Store) Import(name string, r io.Reader, size int64) (err error) {
+	path, err := s.manifestPath(name)
+	if err != nil {
+		return err
+	}
+
+	mf, err := afero.TempFile(fs, filepath.Dir(path), "."+name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := mf.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			fs.Remove(mf.Name())
+			return
+		}
+		err = fs.Rename(mf.Name(), path)
+	}()
+	var pw *packWriter
+	defer func() {
+		if pw != nil {
+			if e := pw.close(); err == nil {
+				err = e
+			}
+		}
+	}()
+
+	h := manifestHeader{
+		Magic:      manifestMagic,
+		SectorSize: uint32(s.sectorSize),
+		Size:       uint64(size),
+	}
+	if err = binary.Write(mf, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+
+	b := make([]byte, s.sectorSize)
+	for off := int64(0); off < size; off += s.sectorSize {
+		// Pad any final short sector with zeroes
+		n := s.sectorSize
+		if size-off < n {
+			n = size - off
+			for i := range b[n:] {
+				b[n+int64(i)] = 0
+			}
+		}
+		if _, err = io.ReadFull(r, b[:n]); err != nil {
+			return err
+		}
+
+		d := digest(sha1.Sum(b))
+		if _, err = mf.Write(d[:]); err != nil {
+			return err
+		}
+
+		if _, ok := s.index[d]; ok {
+			continue
+		}
+
+		// Start a new pack if there isn't one or the current one is full
+		if pw == nil || int64(pw.n+1)*s.sectorSize > maxPackSize {
+			if pw != nil {
+				if err = pw.close(); err != nil {
+					pw = nil
+					return err
+				}
+				pw = nil
+			}
+			i := uint32(len(s.packs))
+			if i > 0 && int64(s.packs[i-1]+1)*s.sectorSize <= maxPackSize {
+				i--
+			} else {
+				s.packs = append(s.packs, 0)
+			}
+			if pw, err = s.openPack(i); err != nil {
+				return err
+			}
+		}
+
+		// Write the sector before the index entry so the index never
+		// refers to a sector that doesn't exist
+		if _, err = pw.pack.Write(b); err != nil {
+			return err
+		}
+		if _, err = pw.idx.Write(d[:]); err != nil {
+			return err
+		}
+
+		i := uint32(len(s.packs) - 1)
+		s.index[d] = location{pack: i, sector: pw.n}
+		pw.n++
+		s.packs[i] = pw.n
+	}
+
+	return nil
+}
+
+// Remove removes the manifest name from the store. Any sectors only used by
+// that manifest remain in the packs.
+func (s *Store) Remove(name string) error {
+	path, err := s.manifestPath(name)
+	if err != nil {
+		return err
+	}
+	if err = fs.Remove(path); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+const testSectorSize = 512
+
+// testImage returns an image of size bytes where every sector is one of
+// unique distinct sectors.
+func testImage(seed int64, size, unique int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	sectors := make([][]byte, unique)
+	for i := range sectors {
+		sectors[i] = make([]byte, testSectorSize)
+		rng.Read(sectors[i])
+	}
+
+	b := make([]byte, 0, size+testSectorSize)
+	for len(b) < size {
+		b = append(b, sectors[rng.Intn(unique)]...)
+	}
+	return b[:size]
+}
+
+func (s *Store) sectors() (n uint32) {
+	for _, p := range s.packs {
+		n += p
+	}
+	return
+}
+
+func testRead(t *testing.T, s *Store, name string, want []byte) {
+	t.Helper()
+
+	rc, err := s.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if rc.Size() != int64(len(want)) {
+		t.Fatalf("got size %d, want %d", rc.Size(), len(want))
+	}
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Error("data does not match")
+	}
+
+	// Read across a sector boundary and the end of the image
+	b = make([]byte, testSectorSize)
+	off := int64(len(want)) - testSectorSize/2
+	n, err := rc.ReadAt(b, off)
+	if n != testSectorSize/2 || err != io.EOF || !bytes.Equal(b[:n], want[off:]) {
+		t.Errorf("got %d, %v reading at %d", n, err, off)
+	}
+}
+
+func TestImport(t *testing.T) {
+	s, err := Create(t.TempDir(), testSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A final short sector is padded
+	image := testImage(1, 100*testSectorSize+100, 10)
+
+	if err = s.Import("first", bytes.NewReader(image), int64(len(image))); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.sectors(); n > 11 {
+		t.Fatalf("got %d sectors, want at most 11", n)
+	}
+	n := s.sectors()
+
+	// Importing the same image again adds no sectors
+	if err = s.Import("second", bytes.NewReader(image), int64(len(image))); err != nil {
+		t.Fatal(err)
+	}
+	if s.sectors() != n {
+		t.Errorf("got %d sectors, want %d", s.sectors(), n)
+	}
+
+	names, err := s.Manifests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Errorf("got manifests %v", names)
+	}
+
+	testRead(t, s, "first", image)
+	testRead(t, s, "second", image)
+
+	if _, err = s.Open("third"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("interrupted")
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func TestInterruptedImport(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Create(dir, testSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := testImage(1, 50*testSectorSize, 20)
+	if err = s.Import("first", bytes.NewReader(first), int64(len(first))); err != nil {
+		t.Fatal(err)
+	}
+
+	second := testImage(2, 50*testSectorSize, 20)
+	if err = s.Import("second", &failingReader{bytes.NewReader(second), 25 * testSectorSize}, int64(len(second))); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err = s.Open("second"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+
+	// Leave a sector in the pack and part of a digest in the index that
+	// the index doesn't describe, as if a write was cut short
+	for ext, b := range map[string][]byte{packExt: make([]byte, testSectorSize), indexExt: make([]byte, 7)} {
+		f, err := os.OpenFile(s.packPath(0, ext), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write(b)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Import("second", bytes.NewReader(second), int64(len(second))); err != nil {
+		t.Fatal(err)
+	}
+
+	testRead(t, s, "first", first)
+	testRead(t, s, "second", second)
+
+	// Reopening again sees the same sectors
+	n := s.sectors()
+	if s, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if s.sectors() != n {
+		t.Errorf("got %d sectors, want %d", s.sectors(), n)
+	}
+
+	testRead(t, s, "first", first)
+	testRead(t, s, "second", second)
+}
@@ -0,0 +1,203 @@
+/*
+Package tga implements a decoder for the uncompressed and run-length encoded
+true-color and grayscale Truevision TGA images used for the icon and boot
+screens of Nintendo Wii-U titles.
+*/
+package tga
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	typeTrueColor    = 2
+	typeGrayscale    = 3
+	typeRLETrueColor = 10
+	typeRLEGrayscale = 11
+
+	descriptorAlpha   = 0x0f
+	descriptorRight   = 0x10
+	descriptorTop     = 0x20
+	packetRunLength   = 0x80
+	packetCountMask   = 0x7f
+	maxImageDimension = 1 << 14
+)
+
+// ErrUnsupported is returned for valid but unsupported TGA images, such as
+// those using a color map.
+var ErrUnsupported = errors.New("tga: unsupported image")
+
+type header struct {
+	IDLength        uint8
+	ColorMapType    uint8
+	ImageType       uint8
+	ColorMapOrigin  uint16
+	ColorMapLength  uint16
+	ColorMapDepth   uint8
+	XOrigin         uint16
+	YOrigin         uint16
+	Width           uint16
+	Height          uint16
+	PixelDepth      uint8
+	ImageDescriptor uint8
+}
+
+func readHeader(r io.Reader) (header, error) {
+	h := header{}
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return h, err
+	}
+
+	switch {
+	case h.ColorMapType != 0:
+		return h, ErrUnsupported
+	case h.ImageType == typeTrueColor || h.ImageType == typeRLETrueColor:
+		if h.PixelDepth != 24 && h.PixelDepth != 32 {
+			return h, ErrUnsupported
+		}
+	case h.ImageType == typeGrayscale || h.ImageType == typeRLEGrayscale:
+		if h.PixelDepth != 8 {
+			return h, ErrUnsupported
+		}
+	default:
+		return h, ErrUnsupported
+	}
+
+	if h.Width == 0 || h.Height == 0 || h.Width > maxImageDimension || h.Height > maxImageDimension {
+		return h, errors.New("tga: bad dimensions")
+	}
+
+	return h, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a TGA image without
+// decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	m := color.NRGBAModel
+	if h.PixelDepth == 8 {
+		m = color.GrayModel
+	}
+
+	return image.Config{
+		ColorModel: m,
+		Width:      int(h.Width),
+		Height:     int(h.Height),
+	}, nil
+}
+
+// Decode reads a TGA image from r and returns it as an image.Image.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the image ID
+	if _, err = io.CopyN(ioutil.Discard, br, int64(h.IDLength)); err != nil {
+		return nil, err
+	}
+
+	bpp := int(h.PixelDepth) / 8
+	w, ht := int(h.Width), int(h.Height)
+	pixels := make([]byte, w*ht*bpp)
+
+	if h.ImageType == typeRLETrueColor || h.ImageType == typeRLEGrayscale {
+		err = decodeRLE(br, pixels, bpp)
+	} else {
+		_, err = io.ReadFull(br, pixels)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alpha := h.PixelDepth == 32 && h.ImageDescriptor&descriptorAlpha != 0
+	rect := image.Rect(0, 0, w, ht)
+
+	var gray *image.Gray
+	var nrgba *image.NRGBA
+	if bpp == 1 {
+		gray = image.NewGray(rect)
+	} else {
+		nrgba = image.NewNRGBA(rect)
+	}
+
+	for y := 0; y < ht; y++ {
+		// Rows are stored bottom to top unless the descriptor says
+		// otherwise
+		dy := ht - 1 - y
+		if h.ImageDescriptor&descriptorTop != 0 {
+			dy = y
+		}
+		for x := 0; x < w; x++ {
+			dx := x
+			if h.ImageDescriptor&descriptorRight != 0 {
+				dx = w - 1 - x
+			}
+			p := pixels[(y*w+x)*bpp:]
+			if gray != nil {
+				gray.Pix[gray.PixOffset(dx, dy)] = p[0]
+				continue
+			}
+			i := nrgba.PixOffset(dx, dy)
+			nrgba.Pix[i+0] = p[2]
+			nrgba.Pix[i+1] = p[1]
+			nrgba.Pix[i+2] = p[0]
+			nrgba.Pix[i+3] = 0xff
+			if alpha {
+				nrgba.Pix[i+3] = p[3]
+			}
+		}
+	}
+
+	if gray != nil {
+		return gray, nil
+	}
+	return nrgba, nil
+}
+
+func decodeRLE(r io.ByteReader, pixels []byte, bpp int) error {
+	pixel := make([]byte, bpp)
+	for i := 0; i < len(pixels); {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		n := int(c&packetCountMask) + 1
+		if i+n*bpp > len(pixels) {
+			return errors.New("tga: bad run length")
+		}
+
+		if c&packetRunLength != 0 {
+			for j := range pixel {
+				if pixel[j], err = r.ReadByte(); err != nil {
+					return err
+				}
+			}
+			for ; n > 0; n-- {
+				i += copy(pixels[i:], pixel)
+			}
+			continue
+		}
+
+		for j := 0; j < n*bpp; j++ {
+			if pixels[i], err = r.ReadByte(); err != nil {
+				return err
+			}
+			i++
+		}
+	}
+	return nil
+}
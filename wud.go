@@ -70,23 +70,26 @@ Example usage:
 package wud
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha1"
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/connesc/cipherio"
+	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
 	"go4.org/readerutil"
 )
@@ -101,13 +104,36 @@ const (
 	// CommonKeyFile represents the standard "common.key" filename
 	CommonKeyFile = "common.key"
 	// GameKeyFile represents the standard "game.key" filename
-	GameKeyFile        = "game.key"
-	keySize            = 16
-	magic       uint32 = 0xcca6e67b
+	GameKeyFile = "game.key"
+	// PartialExtension is added to the name of a file while it is written
+	PartialExtension        = ".partial"
+	keySize                 = 16
+	magic            uint32 = 0xcca6e67b
 )
 
 var fs = afero.NewOsFs()
 
+var (
+	// ErrFileNotFound is returned if the requested file does not exist on
+	// the disc.
+	ErrFileNotFound = errors.New("wud: file not found")
+	// ErrNoCommonKey is returned if the common key is required but none
+	// was provided.
+	ErrNoCommonKey = errors.New("wud: no common key")
+	// ErrWrongSize is returned if the disc image is too small to hold the
+	// partitions listed in the table of contents.
+	ErrWrongSize = errors.New("wud: wrong size")
+	// ErrWrongDiscKey is returned if the table of contents can't be
+	// decrypted with the disc key.
+	ErrWrongDiscKey = errors.New("wud: wrong disc key")
+	// ErrWrongCommonKey is returned if the title key decrypted with the
+	// common key can't decrypt the game partition.
+	ErrWrongCommonKey = errors.New("wud: wrong common key")
+	// ErrBadTOCChecksum is returned if the table of contents decrypts
+	// correctly but its checksum doesn't match.
+	ErrBadTOCChecksum = errors.New("wud: bad TOC checksum")
+)
+
 // A Reader has Read, Seek, ReadAt, and Size methods.
 type Reader interface {
 	io.Reader
@@ -137,7 +163,7 @@ func newPartitionTable(r io.Reader) (partitionTable, error) {
 		return nil, err
 	}
 	if pth.Magic != magic {
-		return nil, errors.New("wud: bad magic")
+		return nil, ErrWrongDiscKey
 	}
 
 	// Skip to offset 0x800
@@ -168,12 +194,37 @@ func newPartitionTable(r io.Reader) (partitionTable, error) {
 
 	// Check the checksum is correct
 	if !bytes.Equal(h.Sum(nil), pth.Checksum[:]) {
-		return nil, errors.New("wud: bad TOC checksum")
+		return nil, ErrBadTOCChecksum
 	}
 
 	return pt, nil
 }
 
+// readPartitionTable decrypts the partition table in the fourth sector using
+// the disc key.
+func readPartitionTable(r io.ReaderAt, game cipher.Block) (partitionTable, error) {
+	sr := io.NewSectionReader(r, 3*int64(SectorSize), int64(SectorSize))
+	return newPartitionTable(cipherio.NewBlockReader(sr, cipher.NewCBCDecrypter(game, make([]byte, game.BlockSize()))))
+}
+
+// CheckDiscKey checks gameKey can decrypt the table of contents of the disc
+// image read from r, returning ErrWrongDiscKey if it can't. This only reads
+// one sector so is a cheap way to find the disc key for an image.
+func CheckDiscKey(r io.ReaderAt, gameKey []byte) error {
+	if len(gameKey) != keySize {
+		return errors.New("wud: wrong game key size")
+	}
+
+	game, err := aes.NewCipher(gameKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = readPartitionTable(r, game)
+
+	return err
+}
+
 func (pt partitionTable) findPartition(prefix string) (string, int64, error) {
 	for k, v := range pt {
 		if strings.HasPrefix(k, prefix) {
@@ -183,6 +234,39 @@ func (pt partitionTable) findPartition(prefix string) (string, int64, error) {
 	return "", 0, errors.New("wud: can't find partition")
 }
 
+// CheckKeys checks commonKey and gameKey can decrypt the disc image read from
+// r. ErrWrongDiscKey or ErrWrongCommonKey is returned to indicate which key is
+// wrong.
+func CheckKeys(r readerutil.SizeReaderAt, commonKey, gameKey []byte) error {
+	w, err := NewWUD(r, commonKey, gameKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.gamePartition()
+
+	return err
+}
+
+// Partition describes a partition on the disc.
+type Partition struct {
+	Name   string
+	Offset int64
+}
+
+// Partitions returns the partitions on the disc in the order they are
+// stored.
+func (w *WUD) Partitions() []Partition {
+	partitions := make([]Partition, 0, len(w.pt))
+	for k, v := range w.pt {
+		partitions = append(partitions, Partition{k, v})
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Offset < partitions[j].Offset
+	})
+	return partitions
+}
+
 const (
 	titleCert = "title.cert"
 	titleTik  = "title.tik"
@@ -201,36 +285,57 @@ func (f file) reader(r io.ReaderAt, block cipher.Block) io.Reader {
 	return io.LimitReader(cbc, f.size)
 }
 
+// ReadProductCode returns the product code, such as "WUP-P-ABCD", stored
+// unencrypted at the start of the disc image. No keys are required.
+func ReadProductCode(r io.ReaderAt) (string, error) {
+	sr := io.NewSectionReader(r, 0, 10)
+	title := make([]byte, 10)
+	if _, err := io.ReadFull(sr, title); err != nil {
+		return "", err
+	}
+	return string(title), nil
+}
+
 // WUD represents a Wii-U disc image
 type WUD struct {
-	r      io.ReaderAt
-	common cipher.Block
-	game   cipher.Block
-	title  string
-	pt     partitionTable
-	files  map[string]file
+	r       io.ReaderAt
+	common  cipher.Block
+	game    cipher.Block
+	title   string
+	pt      partitionTable
+	si      map[string]file
+	siFiles []string
+	files   map[string]file
+	gm      *gamePartition
+	gmErr   error
+	gmOnce  sync.Once
 }
 
 // NewWUD returns a WUD read from the provided r, using the commonKey and
-// gameKey to decrypt where necessary.
+// gameKey to decrypt where necessary. If commonKey is nil then anything that
+// requires the title key, such as the game files, is unavailable. A trimmed
+// image is padded with PadImage and any image is accepted as long as it holds
+// the start of every partition.
 func NewWUD(r readerutil.SizeReaderAt, commonKey, gameKey []byte) (*WUD, error) {
-	w := new(WUD)
-	w.r = r
+	size := r.Size()
 
-	if r.Size() != int64(UncompressedSize) {
-		return nil, errors.New("wud: wrong size")
-	}
+	w := new(WUD)
 
 	var err error
-
-	if len(commonKey) != keySize {
-		return nil, errors.New("wud: wrong common key size")
-	}
-	w.common, err = aes.NewCipher(commonKey)
-	if err != nil {
+	if w.r, err = PadImage(r); err != nil {
 		return nil, err
 	}
 
+	if commonKey != nil {
+		if len(commonKey) != keySize {
+			return nil, errors.New("wud: wrong common key size")
+		}
+		w.common, err = aes.NewCipher(commonKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(gameKey) != keySize {
 		return nil, errors.New("wud: wrong game key size")
 	}
@@ -240,334 +345,410 @@ func NewWUD(r readerutil.SizeReaderAt, commonKey, gameKey []byte) (*WUD, error)
 	}
 
 	// Read title
-	sr := io.NewSectionReader(w.r, 0, 10)
-	title := make([]byte, 10)
-	if _, err = io.ReadFull(sr, title); err != nil {
+	if w.title, err = ReadProductCode(w.r); err != nil {
 		return nil, err
 	}
-	w.title = string(title)
-
-	// Fourth sector
-	sr = io.NewSectionReader(w.r, 3*int64(SectorSize), int64(SectorSize))
-	cbc := cipherio.NewBlockReader(sr, cipher.NewCBCDecrypter(w.game, make([]byte, w.game.BlockSize())))
 
 	// Read the partition table
-	if w.pt, err = newPartitionTable(cbc); err != nil {
+	if w.pt, err = readPartitionTable(w.r, w.game); err != nil {
 		return nil, err
 	}
 
+	// Trimming only removes trailing zeros so every partition, which
+	// starts with a header, must still be present
+	for _, offset := range w.pt {
+		if offset >= size {
+			return nil, ErrWrongSize
+		}
+	}
+
 	si, ok := w.pt["SI"]
 	if !ok {
 		return nil, errors.New("wud: can't find SI partition")
 	}
 
 	// SI partition, skipping the first sector
-	sr = io.NewSectionReader(w.r, si+int64(SectorSize), int64(SectorSize))
-	cbc = cipherio.NewBlockReader(sr, cipher.NewCBCDecrypter(w.game, make([]byte, w.game.BlockSize())))
-
-	fh := struct {
-		Magic                uint32
-		FileOffsetFactor     uint32
-		SecondaryHeaderCount uint32
-		_                    [20]byte
-	}{}
-	if err = binary.Read(cbc, binary.BigEndian, &fh); err != nil {
+	sr := io.NewSectionReader(w.r, si+int64(SectorSize), int64(SectorSize))
+	b := make([]byte, SectorSize)
+	if _, err = io.ReadFull(cipherio.NewBlockReader(sr, cipher.NewCBCDecrypter(w.game, make([]byte, w.game.BlockSize()))), b); err != nil {
 		return nil, err
 	}
-	if fh.Magic != 0x46535400 { // "FST"+0
-		return nil, errors.New("wud: bad magic")
-	}
 
-	// Skip over secondary headers
-	if _, err = io.CopyN(ioutil.Discard, cbc, int64(fh.SecondaryHeaderCount)<<5); err != nil {
+	t, err := parseFST(b)
+	if err != nil {
 		return nil, err
 	}
 
-	// Decrypt the rest of the sector
-	b := new(bytes.Buffer)
-	if _, err = io.Copy(b, cbc); err != nil {
-		return nil, err
-	}
-	br := bytes.NewReader(b.Bytes())
+	w.si = make(map[string]file, len(t.files))
+	for _, f := range t.files {
+		sf := file{
+			iv:     make([]byte, w.game.BlockSize()),
+			offset: si + 2*int64(SectorSize) + f.offset,
+			size:   f.size,
+		}
+		binary.BigEndian.PutUint64(sf.iv[8:], uint64(f.offset>>16))
 
-	fe := struct {
-		TypeName            uint32 // 8 + 24
-		Offset              uint32
-		Size                uint32
-		Flags               uint16
-		StorageClusterIndex uint16
-	}{}
-	if err = binary.Read(br, binary.BigEndian, &fe); err != nil {
-		return nil, err
-	}
-	if fe.TypeName>>24 != 1 || fe.TypeName&0xffffff != 0 {
-		return nil, errors.New("wud: bad root entry")
+		w.si[f.path] = sf
+		w.siFiles = append(w.siFiles, f.path)
 	}
 
-	entries := int(fe.Size)
-	nameTableOffset := int64(entries * int(unsafe.Sizeof(fe)))
+	// The game title is the first with a ticket for a title ID starting
+	// with 0x00050000, use the files in the same directory
 	w.files = make(map[string]file)
-
-	for i := 1; i < entries; i++ {
-		if err = binary.Read(br, binary.BigEndian, &fe); err != nil {
-			return nil, err
-		}
-
-		// Not a file
-		if fe.TypeName>>24 != 0 {
+	for _, name := range w.siFiles {
+		if path.Base(name) != titleTik {
 			continue
 		}
 
-		// Remember where we are
-		pos, err := br.Seek(0, io.SeekCurrent)
+		tid, err := w.readTitleID(name, 0x1dc)
 		if err != nil {
 			return nil, err
 		}
-
-		// Seek to the filename
-		if _, err = br.Seek(nameTableOffset+int64(fe.TypeName&0xffffff), io.SeekStart); err != nil {
-			return nil, err
+		if tid>>32 != 0x50000 {
+			continue
 		}
 
-		// Read until null
-		rawBytes, err := bufio.NewReader(br).ReadBytes(0)
-		if err != nil {
-			return nil, err
+		dir := path.Dir(name)
+		for _, filename := range []string{titleTik, titleTmd, titleCert} {
+			if f, ok := w.si[path.Join(dir, filename)]; ok {
+				w.files[filename] = f
+			}
 		}
-		// XXX Any encoding here such as japanese.ShiftJIS?
-		filename := string(rawBytes[:len(rawBytes)-1])
+		break
+	}
 
-		// Seek back to where we were
-		if _, err = br.Seek(pos, io.SeekStart); err != nil {
-			return nil, err
+	// Any certificate chain will do
+	if _, ok := w.files[titleCert]; !ok {
+		for _, name := range w.siFiles {
+			if path.Base(name) == titleCert {
+				w.files[titleCert] = w.si[name]
+				break
+			}
 		}
+	}
 
-		if _, ok = w.files[filename]; ok {
-			continue
-		}
+	return w, nil
+}
 
-		f := file{
-			iv:     make([]byte, w.game.BlockSize()),
-			offset: si + 2*int64(SectorSize) + int64(fe.Offset*fh.FileOffsetFactor),
-			size:   int64(fe.Size),
-		}
-		binary.BigEndian.PutUint64(f.iv[8:], uint64(fe.Offset*fh.FileOffsetFactor>>16))
+// readTitleID reads the title ID at offset in the SI partition file name.
+func (w *WUD) readTitleID(name string, offset int64) (uint64, error) {
+	f, ok := w.si[name]
+	if !ok {
+		return 0, ErrFileNotFound
+	}
 
-		if filename == titleCert {
-			w.files[titleCert] = f
-			continue
-		}
+	r := f.reader(w.r, w.game)
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		return 0, err
+	}
 
-		lr := f.reader(w.r, w.game)
+	var tid uint64
+	if err := binary.Read(r, binary.BigEndian, &tid); err != nil {
+		return 0, err
+	}
 
-		offsets := map[string]int64{
-			titleTik: 0x1dc,
-			titleTmd: 0x18c,
-		}
+	return tid, nil
+}
 
-		if offset, ok := offsets[filename]; ok {
-			if _, err = io.CopyN(ioutil.Discard, lr, offset); err != nil {
-				return nil, err
-			}
-		} else {
+// Title describes a title with a ticket or title metadata in the SI
+// partition.
+type Title struct {
+	Directory     string // Directory in the SI partition, such as "01"
+	TitleID       uint64 // Title ID in the title metadata, if there is any
+	Ticket        string // Path of the ticket, empty if there isn't one
+	TicketTitleID uint64 // Title ID in the ticket, if there is one
+}
+
+// Titles returns every title with a ticket or title metadata in the SI
+// partition, which can include system updates as well as the game itself.
+func (w *WUD) Titles() ([]Title, error) {
+	var titles []Title
+	index := make(map[string]int)
+	for _, name := range w.siFiles {
+		var offset int64
+		switch path.Base(name) {
+		case titleTmd:
+			offset = 0x18c
+		case titleTik:
+			offset = 0x1dc
+		default:
 			continue
 		}
 
-		var tid uint32
-		if err = binary.Read(lr, binary.BigEndian, &tid); err != nil {
+		tid, err := w.readTitleID(name, offset)
+		if err != nil {
 			return nil, err
 		}
-		if tid != 0x50000 {
-			continue
+
+		dir := path.Dir(name)
+		i, ok := index[dir]
+		if !ok {
+			i = len(titles)
+			index[dir] = i
+			titles = append(titles, Title{Directory: dir})
 		}
 
-		w.files[filename] = f
+		if path.Base(name) == titleTmd {
+			titles[i].TitleID = tid
+		} else {
+			titles[i].Ticket, titles[i].TicketTitleID = name, tid
+		}
 	}
 
-	return w, nil
+	return titles, nil
+}
+
+// SIFiles returns the full path of every file in the SI partition, such as
+// "01/title.tmd".
+func (w *WUD) SIFiles() []string {
+	return append([]string{}, w.siFiles...)
 }
 
-func (w *WUD) extractFile(filename, target string) (io.Reader, io.Closer, error) {
-	f, ok := w.files[filename]
+// ReadSIFile returns the contents of the file name in the SI partition.
+func (w *WUD) ReadSIFile(name string) ([]byte, error) {
+	f, ok := w.si[strings.TrimPrefix(path.Clean("/"+name), "/")]
 	if !ok {
-		return nil, nil, errors.New("wud: file not found")
+		return nil, ErrFileNotFound
 	}
-	wc, err := fs.Create(target)
+
+	return ioutil.ReadAll(f.reader(w.r, w.game))
+}
+
+// writeFile writes the contents of r to a temporary file alongside name which
+// is then renamed to name, so name is never left partially written.
+func writeFile(name string, r io.Reader) (err error) {
+	// Created the same as any other file so the umask applies
+	f, err := fs.OpenFile(name+PartialExtension, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	return io.TeeReader(f.reader(w.r, w.game), wc), wc, nil
-}
 
-// Extract writes all of the files from the underlying disc image to the passed
-// directory, which is created if necessary.
-func (w *WUD) Extract(directory string) error {
-	directory = filepath.Join(directory, w.title)
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(f.Name())
+		}
+	}()
 
-	if err := fs.MkdirAll(directory, os.ModePerm|os.ModeDir); err != nil {
-		return err
+	if _, err = io.Copy(f, r); err != nil {
+		return multierror.Append(err, f.Close())
 	}
 
-	tr, c, err := w.extractFile(titleTmd, filepath.Join(directory, titleTmd))
-	if err != nil {
+	if err = f.Close(); err != nil {
 		return err
 	}
-	defer c.Close()
-
-	tmd := struct {
-		SignatureType    uint32
-		Signature        [0x100]byte
-		_                [0x3c]byte
-		Issuer           [0x40]byte
-		Version          byte
-		CACRLVersion     byte
-		SignerCRLVersion byte
-		_                byte
-		SystemVersion    uint64
-		TitleID          uint64
-		TitleType        uint32
-		GroupID          uint16
-		_                [62]byte
-		AccessRights     uint32
-		TitleVersion     uint16
-		ContentCount     uint16
-		BootIndex        uint16
-		_                [2]byte
-		SHA2             [sha256.Size]byte
-
-		ContentInfos [64]struct {
-			IndexOffset  uint16
-			CommandCount uint16
-			SHA2         [sha256.Size]byte
-		}
-	}{}
 
-	if err = binary.Read(tr, binary.BigEndian, &tmd); err != nil {
-		return err
+	return fs.Rename(f.Name(), name)
+}
+
+// ExtractOptions configures ExtractContext.
+type ExtractOptions struct {
+	// Progress, if not nil, receives updates as each file is written.
+	Progress Progress
+	// ContentIDs, if not empty, limits extraction to the contents with a
+	// matching ID. It is an error if any ID matches no content.
+	ContentIDs []uint32
+	// Include and Exclude are path.Match patterns, such as
+	// "content/Movie/*", matched against the paths of the decrypted game
+	// files. If either is set then the matching files are decrypted and
+	// written instead of the contents. A pattern matching a directory
+	// matches everything beneath it and an empty Include matches every
+	// file.
+	Include []string
+	Exclude []string
+	// Escape, if not nil, encodes any names of decrypted game files that
+	// are neither UTF-8 nor Shift-JIS instead of EscapeName.
+	Escape func([]byte) string
+}
+
+type extractEntry struct {
+	name string
+	r    io.Reader
+	size int64
+}
+
+// matchPath returns whether name, or any directory containing it, matches
+// any of the patterns.
+func matchPath(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		for p := name; p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	contents := make([]struct {
-		ID    uint32
-		Index uint16
-		Type  uint16
-		Size  uint64
-		SHA2  [sha256.Size]byte
-	}, tmd.ContentCount)
+// Extract writes all of the files from the underlying disc image to the passed
+// directory, which is created if necessary. Each file is written to a
+// temporary file first and if extraction fails, any files already written are
+// removed.
+func (w *WUD) Extract(directory string) error {
+	return w.ExtractContext(context.Background(), directory, nil)
+}
 
-	if err = binary.Read(tr, binary.BigEndian, &contents); err != nil {
-		return err
+// ExtractContext is like Extract but stops once ctx is done and uses opts,
+// which may be nil, to report progress and select what is extracted.
+func (w *WUD) ExtractContext(ctx context.Context, directory string, opts *ExtractOptions) (err error) {
+	if opts == nil {
+		opts = new(ExtractOptions)
 	}
 
-	if _, err = io.Copy(ioutil.Discard, tr); err != nil {
-		return err
+	for _, pattern := range append(opts.Include, opts.Exclude...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return err
+		}
 	}
 
-	_, gm, err := w.pt.findPartition(fmt.Sprintf("GM%016X", tmd.TitleID))
+	gm, err := w.gamePartition()
 	if err != nil {
 		return err
 	}
 
-	sr := io.NewSectionReader(w.r, gm, int64(SectorSize))
-	if _, err = io.CopyN(ioutil.Discard, sr, 0x10); err != nil {
-		return err
-	}
-	var headerCount uint32
-	if err = binary.Read(sr, binary.BigEndian, &headerCount); err != nil {
-		return err
+	selected := make(map[uint16]bool)
+	for _, c := range gm.contents {
+		selected[c.Index] = len(opts.ContentIDs) == 0
 	}
-	if _, err = io.CopyN(ioutil.Discard, sr, 0x2c+int64(headerCount)<<2); err != nil {
-		return err
+	for _, id := range opts.ContentIDs {
+		found := false
+		for _, c := range gm.contents {
+			if c.ID == id {
+				selected[c.Index], found = true, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("wud: no content with ID %08x", id)
+		}
 	}
-	// sr is now pointing to the first hash
 
-	if tr, c, err = w.extractFile(titleTik, filepath.Join(directory, titleTik)); err != nil {
-		return err
+	var entries []extractEntry
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		entries, err = gm.fileEntries(selected, opts.Include, opts.Exclude, opts.Escape)
+	} else {
+		entries, err = w.contentEntries(gm, selected)
 	}
-	defer c.Close()
-	if _, err = io.CopyN(ioutil.Discard, tr, 0x1bf); err != nil {
-		return err
-	}
-	key := make([]byte, keySize)
-	if _, err = io.ReadFull(tr, key); err != nil {
-		return err
-	}
-	if _, err = io.CopyN(ioutil.Discard, tr, 0x1dc-(aes.BlockSize+0x1bf)); err != nil {
-		return err
-	}
-	iv := make([]byte, w.common.BlockSize())
-	if _, err = io.ReadFull(tr, iv[:8]); err != nil {
-		return err
-	}
-	if _, err = io.Copy(ioutil.Discard, tr); err != nil {
+	if err != nil {
 		return err
 	}
-	cipher.NewCBCDecrypter(w.common, iv).CryptBlocks(key, key)
 
-	tik, err := aes.NewCipher(key)
-	if err != nil {
+	directory = filepath.Join(directory, w.title)
+
+	if err = fs.MkdirAll(directory, os.ModePerm|os.ModeDir); err != nil {
 		return err
 	}
-	iv = make([]byte, tik.BlockSize())
-	binary.BigEndian.PutUint16(iv[:2], contents[0].Index)
 
-	f, err := fs.Create(filepath.Join(directory, fmt.Sprintf("%08x.app", contents[0].ID)))
-	if err != nil {
-		return err
+	var written []string
+	defer func() {
+		if err != nil {
+			for _, name := range written {
+				_ = fs.Remove(name)
+			}
+		}
+	}()
+
+	c := &counter{ctx: ctx, progress: opts.Progress}
+	for _, e := range entries {
+		c.total += e.size
 	}
-	defer f.Close()
 
-	tr = io.TeeReader(io.NewSectionReader(w.r, gm+int64(SectorSize), int64((int(contents[0].Size)+tik.BlockSize()-1)&(-tik.BlockSize()))), f)
-	cbc := cipherio.NewBlockReader(tr, cipher.NewCBCDecrypter(tik, iv))
+	for _, e := range entries {
+		target := filepath.Join(directory, filepath.FromSlash(e.name))
+		if err = fs.MkdirAll(filepath.Dir(target), os.ModePerm|os.ModeDir); err != nil {
+			return err
+		}
+		if err = writeFile(target, c.reader(e.name, e.r)); err != nil {
+			return err
+		}
+		written = append(written, target)
+	}
 
-	app := make([]struct {
-		Offset uint32
-		Size   uint32
-		TID    uint64
-		GID    uint32
-		_      [0xc]byte
-	}, tmd.ContentCount)
+	return nil
+}
 
-	if _, err = io.CopyN(ioutil.Discard, cbc, 0x20); err != nil {
-		return err
+// contentEntries returns the ticket, title metadata and certificates along
+// with the selected contents and their hashes.
+func (w *WUD) contentEntries(gm *gamePartition, selected map[uint16]bool) ([]extractEntry, error) {
+	var entries []extractEntry
+	addFile := func(filename string) error {
+		f, ok := w.files[filename]
+		if !ok {
+			return ErrFileNotFound
+		}
+		entries = append(entries, extractEntry{filename, f.reader(w.r, w.game), f.size})
+		return nil
 	}
-	if err = binary.Read(cbc, binary.BigEndian, &app); err != nil {
-		return err
+
+	for _, filename := range []string{titleTmd, titleTik} {
+		if err := addFile(filename); err != nil {
+			return nil, err
+		}
 	}
-	if _, err = io.Copy(ioutil.Discard, cbc); err != nil {
-		return err
+
+	var headerCount uint32
+	if err := binary.Read(io.NewSectionReader(w.r, gm.offset+0x10, 4), binary.BigEndian, &headerCount); err != nil {
+		return nil, err
 	}
+	h3 := gm.offset + 0x40 + int64(headerCount)<<2 // Offset of the first hash
 
-	for i := 1; i < int(tmd.ContentCount); i++ {
-		f, err = fs.Create(filepath.Join(directory, fmt.Sprintf("%08x.app", contents[i].ID)))
-		if err != nil {
-			return err
+	for i, c := range gm.contents {
+		size := int64(c.Size)
+		if i == 0 {
+			size = (size + aes.BlockSize - 1) &^ (aes.BlockSize - 1)
 		}
-		defer f.Close()
-
-		if _, err = io.Copy(f, io.NewSectionReader(w.r, gm+int64(app[i].Offset)*int64(SectorSize), int64(contents[i].Size))); err != nil {
-			return err
+		if selected[c.Index] {
+			entries = append(entries, extractEntry{fmt.Sprintf("%08x.app", c.ID), io.NewSectionReader(w.r, gm.contentOffset(i), size), size})
 		}
 
-		if contents[i].Type&0x2 != 0 {
-			f, err = fs.Create(filepath.Join(directory, fmt.Sprintf("%08x.h3", contents[i].ID)))
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-
-			if _, err = io.CopyN(f, sr, int64(20*(contents[i].Size/0x10000000+1))); err != nil {
-				return err
+		if c.Hashed() {
+			size = int64(20 * (c.Size/0x10000000 + 1))
+			if selected[c.Index] {
+				entries = append(entries, extractEntry{fmt.Sprintf("%08x.h3", c.ID), io.NewSectionReader(w.r, h3, size), size})
 			}
+			h3 += size
 		}
 	}
 
-	if tr, c, err = w.extractFile(titleCert, filepath.Join(directory, titleCert)); err != nil {
-		return err
+	if err := addFile(titleCert); err != nil {
+		return nil, err
 	}
-	defer c.Close()
-	if _, err = io.Copy(ioutil.Discard, tr); err != nil {
-		return err
+
+	return entries, nil
+}
+
+// fileEntries returns the decrypted game files in the selected contents that
+// match include and don't match exclude, named using escape.
+func (gm *gamePartition) fileEntries(selected map[uint16]bool, include, exclude []string, escape func([]byte) string) ([]extractEntry, error) {
+	var entries []extractEntry
+	names := make(map[string][]byte)
+	for _, f := range gm.fst.files {
+		if !selected[f.cluster] {
+			continue
+		}
+		if len(include) > 0 && !matchPath(include, f.path) || matchPath(exclude, f.path) {
+			continue
+		}
+
+		name := f.path
+		if escape != nil {
+			name = decodePath(f.raw, escape)
+		}
+		if c := path.Clean("/" + name); c != "/"+name {
+			return nil, errors.New("wud: bad file path")
+		}
+
+		// Escaping isn't necessarily reversible so two files could end
+		// up with the same name
+		if raw, ok := names[name]; ok {
+			return nil, fmt.Errorf("wud: %q and %q are both extracted as %s", raw, f.raw, name)
+		}
+		names[name] = f.raw
+
+		sr, err := gm.open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, extractEntry{name, sr, f.size})
 	}
 
-	return nil
+	return entries, nil
 }
@@ -7,11 +7,13 @@ import (
 	"unsafe"
 
 	"github.com/bodgit/wud"
+	"github.com/hashicorp/go-multierror"
 	"go4.org/readerutil"
 )
 
 type reader struct {
 	r          io.ReaderAt
+	parent     wud.Reader
 	base       int64
 	off        int64
 	limit      int64
@@ -21,18 +23,34 @@ type reader struct {
 
 type readcloser struct {
 	r wud.Reader
-	c io.Closer
+	c []io.Closer
 }
 
 var (
 	// ErrBadMagic is returned if the first eight bytes do not contain the correct values.
 	ErrBadMagic = errors.New("wux: bad magic")
+	// ErrParentRequired is returned if the image references sectors in a
+	// parent image but no parent was provided.
+	ErrParentRequired = errors.New("wux: parent image required")
+	// ErrIncomplete is returned if the image was not completely written.
+	ErrIncomplete = errors.New("wux: incomplete image")
 )
 
 // NewReader returns a new wud.Reader that reads and decompresses from ra.
 func NewReader(ra io.ReaderAt) (wud.Reader, error) {
+	return newReader(ra, nil)
+}
+
+// NewChildReader returns a new wud.Reader that reads and decompresses from
+// ra, reading any sectors not stored in ra from parent.
+func NewChildReader(ra io.ReaderAt, parent wud.Reader) (wud.Reader, error) {
+	return newReader(ra, parent)
+}
+
+func newReader(ra io.ReaderAt, parent wud.Reader) (wud.Reader, error) {
 	r := new(reader)
 	r.r = ra
+	r.parent = parent
 
 	h := header{}
 	const headerSize = int64(unsafe.Sizeof(h))
@@ -51,6 +69,15 @@ func NewReader(ra io.ReaderAt) (wud.Reader, error) {
 		return nil, errors.New("wux: bad sector size")
 	}
 
+	switch {
+	case h.Flags&flagIncomplete != 0:
+		return nil, ErrIncomplete
+	case h.Flags&flagParent != 0 && parent == nil:
+		return nil, ErrParentRequired
+	case h.Flags&flagParent == 0 && parent != nil:
+		return nil, errors.New("wux: image has no parent")
+	}
+
 	r.limit = int64(h.UncompressedSize)
 	r.sectorSize = int64(h.SectorSize)
 
@@ -66,6 +93,15 @@ func NewReader(ra io.ReaderAt) (wud.Reader, error) {
 		return nil, err
 	}
 
+	// Check any parent sectors are within the parent image
+	if parent != nil {
+		for _, v := range r.table {
+			if v&parentSector != 0 && int64(v&^parentSector+1)*r.sectorSize > parent.Size() {
+				return nil, errors.New("wux: parent sector out of range")
+			}
+		}
+	}
+
 	// Calculate start of sectors, rounded up to the next whole sector
 	r.base = (headerSize + tableSize<<2 + r.sectorSize - 1) & (-r.sectorSize)
 
@@ -80,7 +116,22 @@ func NewReadCloser(rac readerutil.ReaderAtCloser) (wud.ReadCloser, error) {
 	if rc.r, err = NewReader(rac); err != nil {
 		return nil, err
 	}
-	rc.c = rac
+	rc.c = []io.Closer{rac}
+
+	return rc, nil
+}
+
+// NewChildReadCloser returns a new wud.ReadCloser that reads and decompresses
+// from rac, reading any sectors not stored in rac from parent. Closing it
+// also closes parent.
+func NewChildReadCloser(rac readerutil.ReaderAtCloser, parent wud.ReadCloser) (wud.ReadCloser, error) {
+	rc := new(readcloser)
+
+	var err error
+	if rc.r, err = NewChildReader(rac, parent); err != nil {
+		return nil, err
+	}
+	rc.c = []io.Closer{rac, parent}
 
 	return rc, nil
 }
@@ -98,7 +149,11 @@ func (r *reader) newSizeReaderAt(l, off int64) readerutil.SizeReaderAt {
 		if limit > l {
 			limit = l
 		}
-		sr = append(sr, io.NewSectionReader(r.r, r.base+int64(r.table[sectorIndex])*r.sectorSize+sectorOffset, limit))
+		if v := r.table[sectorIndex]; r.parent != nil && v&parentSector != 0 {
+			sr = append(sr, io.NewSectionReader(r.parent, int64(v&^parentSector)*r.sectorSize+sectorOffset, limit))
+		} else {
+			sr = append(sr, io.NewSectionReader(r.r, r.base+int64(v)*r.sectorSize+sectorOffset, limit))
+		}
 		l -= sr[len(sr)-1].Size()
 		off += sr[len(sr)-1].Size()
 	}
@@ -166,6 +221,11 @@ func (rc *readcloser) Size() int64 {
 	return rc.r.Size()
 }
 
-func (rc *readcloser) Close() error {
-	return rc.c.Close()
+func (rc *readcloser) Close() (err error) {
+	for _, c := range rc.c {
+		if e := c.Close(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return
 }
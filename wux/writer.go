@@ -9,66 +9,263 @@ import (
 	"io"
 	"io/ioutil"
 	"unsafe"
+
+	"github.com/bodgit/wud"
 )
 
+const checkpointInterval = 1 << 15 // Sectors between each checkpoint
+
 type writer struct {
 	w          io.WriteSeeker
 	b          *bytes.Buffer
 	h          hash.Hash
 	err        error
 	m          map[string]uint32
+	p          *ParentIndex
 	off        int64
 	limit      int64
+	base       int64
 	sectorSize int64
+	flags      uint32
 	unique     uint32
 	sector     int
 	table      []uint32
 }
 
 // NewWriter returns an io.WriteCloser that compresses and writes to ws in sectorSize chunks.
+//
+// The index table is periodically written to ws, so if writing is
+// interrupted it can be resumed with NewResumeWriter.
 func NewWriter(ws io.WriteSeeker, sectorSize uint32, uncompressedSize uint64) (io.WriteCloser, error) {
-	w := &writer{
-		w: ws,
-		b: new(bytes.Buffer),
-		h: sha1.New(),
-		m: make(map[string]uint32),
+	w, err := newWriter(ws, sectorSize, uncompressedSize, 0)
+	if err != nil {
+		return nil, err
 	}
+	return w, nil
+}
 
-	// Just to be sure
-	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+// ParentIndex records where each distinct sector of a parent image is. It can
+// be shared between writers so the parent only needs to be read once however
+// many child images are written.
+type ParentIndex struct {
+	sectorSize int64
+	m          map[string]uint32
+}
+
+// NewParentIndex reads every whole sectorSize sector in parent, the first
+// occurrence of any duplicates wins.
+func NewParentIndex(parent wud.Reader, sectorSize uint32) (*ParentIndex, error) {
+	p := &ParentIndex{
+		sectorSize: int64(sectorSize),
+		m:          make(map[string]uint32),
+	}
+
+	h := sha1.New()
+	sr := io.NewSectionReader(parent, 0, parent.Size())
+	b := make([]byte, p.sectorSize)
+	for i := int64(0); (i+1)*p.sectorSize <= parent.Size(); i++ {
+		if i >= int64(parentSector) {
+			return nil, errors.New("wux: parent image too large")
+		}
+		if _, err := io.ReadFull(sr, b); err != nil {
+			return nil, err
+		}
+		h.Reset()
+		_, _ = h.Write(b)
+		k := string(h.Sum(nil))
+		if _, ok := p.m[k]; !ok {
+			p.m[k] = uint32(i)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *ParentIndex) lookup(k string) (uint32, bool) {
+	if p == nil {
+		return 0, false
+	}
+	v, ok := p.m[k]
+	return v, ok
+}
+
+// NewChildWriter returns an io.WriteCloser that compresses and writes to ws
+// in sectorSize chunks. Any sector that is also present in the parent is not
+// written, instead the index table references the sector in the parent. The
+// same parent image must be passed to NewChildReader to read the image back.
+func NewChildWriter(ws io.WriteSeeker, parent *ParentIndex, sectorSize uint32, uncompressedSize uint64) (io.WriteCloser, error) {
+	if parent.sectorSize != int64(sectorSize) {
+		return nil, errors.New("wux: parent sector size mismatch")
+	}
+
+	w, err := newWriter(ws, sectorSize, uncompressedSize, flagParent)
+	if err != nil {
 		return nil, err
 	}
+	w.p = parent
 
-	h := header{
-		Magic:            [2]uint32{magic0, magic1},
-		SectorSize:       sectorSize,
-		UncompressedSize: uncompressedSize,
+	return w, nil
+}
+
+// NewResumeWriter returns an io.WriteCloser that continues writing the
+// incomplete image in rws from the last checkpoint. The offset in the
+// uncompressed image to continue writing from is also returned. If the image
+// was created with NewChildWriter then an index of the same parent must be
+// passed, otherwise parent should be nil.
+func NewResumeWriter(rws io.ReadWriteSeeker, parent *ParentIndex) (io.WriteCloser, int64, error) {
+	if _, err := rws.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
 	}
+
+	h := header{}
 	const headerSize = int64(unsafe.Sizeof(h))
 
-	// Write out header
-	if err := binary.Write(w.w, binary.LittleEndian, &h); err != nil {
-		return nil, err
+	if err := binary.Read(rws, binary.LittleEndian, &h); err != nil {
+		return nil, 0, err
+	}
+	if h.Magic[0] != magic0 || h.Magic[1] != magic1 {
+		return nil, 0, ErrBadMagic
+	}
+	if h.Flags&flagIncomplete == 0 {
+		return nil, 0, errors.New("wux: image is already complete")
+	}
+	switch {
+	case h.Flags&flagParent != 0 && parent == nil:
+		return nil, 0, ErrParentRequired
+	case h.Flags&flagParent == 0 && parent != nil:
+		return nil, 0, errors.New("wux: image has no parent")
+	case parent != nil && parent.sectorSize != int64(h.SectorSize):
+		return nil, 0, errors.New("wux: parent sector size mismatch")
+	}
+
+	w := &writer{
+		w:          rws,
+		b:          new(bytes.Buffer),
+		h:          sha1.New(),
+		m:          make(map[string]uint32),
+		limit:      int64(h.UncompressedSize),
+		sectorSize: int64(h.SectorSize),
+		flags:      h.Flags &^ flagIncomplete,
+		p:          parent,
+	}
+	if w.sectorSize < 0x100 || w.sectorSize >= 0x10000000 {
+		return nil, 0, errors.New("wux: bad sector size")
+	}
+
+	tableSize := (w.limit + w.sectorSize - 1) / w.sectorSize
+	w.table = make([]uint32, tableSize)
+	if err := binary.Read(rws, binary.LittleEndian, &w.table); err != nil {
+		return nil, 0, err
+	}
+	w.base = (headerSize + tableSize<<2 + w.sectorSize - 1) & (-w.sectorSize)
+
+	// Find the last complete sector and how many unique sectors that used
+	for w.sector < len(w.table) && w.table[w.sector] != unwrittenSector {
+		if v := w.table[w.sector]; w.flags&flagParent == 0 || v&parentSector == 0 {
+			if v+1 > w.unique {
+				w.unique = v + 1
+			}
+		}
+		w.sector++
+	}
+	for _, v := range w.table[w.sector:] {
+		if v != unwrittenSector {
+			return nil, 0, errors.New("wux: bad checkpoint")
+		}
+	}
+	w.off = int64(w.sector) * w.sectorSize
+
+	// Rebuild the map by rescanning the sectors written so far
+	b := make([]byte, w.sectorSize)
+	if _, err := rws.Seek(w.base, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	for i := uint32(0); i < w.unique; i++ {
+		if _, err := io.ReadFull(rws, b); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.New("wux: bad checkpoint")
+			}
+			return nil, 0, err
+		}
+		w.h.Reset()
+		_, _ = w.h.Write(b)
+		w.m[string(w.h.Sum(nil))] = i
+	}
+
+	// Anything after the sectors from the last checkpoint is overwritten
+	if _, err := rws.Seek(w.base+int64(w.unique)*w.sectorSize, io.SeekStart); err != nil {
+		return nil, 0, err
 	}
 
-	w.limit = int64(h.UncompressedSize)
-	w.sectorSize = int64(h.SectorSize)
+	return w, w.off, nil
+}
+
+func newWriter(ws io.WriteSeeker, sectorSize uint32, uncompressedSize uint64, flags uint32) (*writer, error) {
+	w := &writer{
+		w:     ws,
+		b:     new(bytes.Buffer),
+		h:     sha1.New(),
+		m:     make(map[string]uint32),
+		flags: flags,
+	}
+
+	w.limit = int64(uncompressedSize)
+	w.sectorSize = int64(sectorSize)
 
 	// Calculate the number of sectors in the uncompressed image
 	tableSize := (w.limit + w.sectorSize - 1) / w.sectorSize
 	w.table = make([]uint32, tableSize)
+	for i := range w.table {
+		w.table[i] = unwrittenSector
+	}
 
 	// Calculate start of sectors, rounded up to the next whole sector
-	off := (headerSize + tableSize<<2 + w.sectorSize - 1) & (-w.sectorSize)
+	const headerSize = int64(unsafe.Sizeof(header{}))
+	w.base = (headerSize + tableSize<<2 + w.sectorSize - 1) & (-w.sectorSize)
 
-	// Seek to the start of the sectors
-	if _, err := w.w.Seek(off, io.SeekStart); err != nil {
+	// Write out header and the empty table
+	if err := w.checkpoint(false); err != nil {
 		return nil, err
 	}
 
 	return w, nil
 }
 
+// checkpoint writes the header and the index table so far, then seeks back
+// to where the next sector will be written.
+func (w *writer) checkpoint(complete bool) error {
+	// Make sure the sectors are written before the table that refers to them
+	if s, ok := w.w.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := header{
+		Magic:            [2]uint32{magic0, magic1},
+		SectorSize:       uint32(w.sectorSize),
+		UncompressedSize: uint64(w.limit),
+		Flags:            w.flags,
+	}
+	if !complete {
+		h.Flags |= flagIncomplete
+	}
+
+	if err := binary.Write(w.w, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	if err := binary.Write(w.w, binary.LittleEndian, &w.table); err != nil {
+		return err
+	}
+
+	_, err := w.w.Seek(w.base+int64(w.unique)*w.sectorSize, io.SeekStart)
+	return err
+}
+
 func (w *writer) Write(p []byte) (n int, err error) {
 	if w.err != nil {
 		return 0, w.err
@@ -80,24 +277,40 @@ func (w *writer) Write(p []byte) (n int, err error) {
 
 	// We have at least a sectors worth of data
 	for int64(w.b.Len()) >= w.sectorSize {
+		if w.sector >= len(w.table) {
+			w.err = errors.New("wux: too much data written")
+			return n, w.err
+		}
+
 		// Calculate the digest of the sector
 		w.h.Reset()
 		_, _ = w.h.Write(w.b.Bytes()[0:w.sectorSize])
 		k := string(w.h.Sum(nil))
 
+		// Sector is in the parent image so it can be dropped
+		if v, ok := w.p.lookup(k); ok {
+			w.table[w.sector] = v | parentSector
+			w.sector++
+			w.b.Next(int(w.sectorSize))
+			if err := w.maybeCheckpoint(); err != nil {
+				return n, err
+			}
+			continue
+		}
+
 		v, ok := w.m[k]
 
 		// Never seen this sector before, assign it the next index
 		if !ok {
+			if w.p != nil && w.unique >= parentSector {
+				w.err = errors.New("wux: too many unique sectors")
+				return n, w.err
+			}
 			v = w.unique
 			w.unique++
 			w.m[k] = v
 		}
 
-		// Record which index this sector uses
-		w.table[w.sector] = v
-		w.sector++
-
 		// Append the sector to the underlying writer, or drop it if
 		// we've seen it before
 		var writer io.Writer = ioutil.Discard
@@ -108,11 +321,30 @@ func (w *writer) Write(p []byte) (n int, err error) {
 			w.err = err
 			return n, err
 		}
+
+		// Record which index this sector uses
+		w.table[w.sector] = v
+		w.sector++
+
+		if err := w.maybeCheckpoint(); err != nil {
+			return n, err
+		}
 	}
 
 	return n, nil
 }
 
+func (w *writer) maybeCheckpoint() error {
+	if w.sector%checkpointInterval != 0 {
+		return nil
+	}
+	if err := w.checkpoint(false); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
 func (w *writer) Close() error {
 	if w.err != nil {
 		return w.err
@@ -122,13 +354,15 @@ func (w *writer) Close() error {
 		return errors.New("wux: not enough data written")
 	}
 
-	const headerSize = int64(unsafe.Sizeof(header{}))
-
-	if _, err := w.w.Seek(headerSize, io.SeekStart); err != nil {
+	if err := w.checkpoint(true); err != nil {
 		return err
 	}
-	if err := binary.Write(w.w, binary.LittleEndian, &w.table); err != nil {
-		return err
+
+	// Remove anything left over from before the image was resumed
+	if t, ok := w.w.(interface{ Truncate(int64) error }); ok {
+		if err := t.Truncate(w.base + int64(w.unique)*w.sectorSize); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,101 @@
+package wux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSectorSize = 0x100
+
+func testImage(sectors ...byte) []byte {
+	b := make([]byte, 0, len(sectors)*testSectorSize)
+	for _, s := range sectors {
+		sector := make([]byte, testSectorSize)
+		rand.New(rand.NewSource(int64(s))).Read(sector)
+		b = append(b, sector...)
+	}
+	return b
+}
+
+func TestChildWriterSharedParent(t *testing.T) {
+	parent := testImage(1, 2, 3, 4)
+	p, err := NewParentIndex(io.NewSectionReader(bytes.NewReader(parent), 0, int64(len(parent))), testSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		image []byte
+		size  int64
+	}{
+		{"shared", testImage(1, 2, 5, 4), 2 * testSectorSize},
+		{"unique", testImage(6, 7, 8, 9), 5 * testSectorSize},
+		{"duplicate", testImage(3, 3, 5, 5), 2 * testSectorSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "child"+Extension)
+			f, err := os.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			w, err := NewChildWriter(f, p, testSectorSize, uint64(len(tt.image)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write(tt.image); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != tt.size {
+				t.Errorf("got size %d, want %d", fi.Size(), tt.size)
+			}
+
+			r, err := NewChildReader(f, io.NewSectionReader(bytes.NewReader(parent), 0, int64(len(parent))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, tt.image) {
+				t.Error("image does not match")
+			}
+		})
+	}
+}
+
+func TestChildWriterSectorSizeMismatch(t *testing.T) {
+	parent := testImage(1)
+	p, err := NewParentIndex(io.NewSectionReader(bytes.NewReader(parent), 0, int64(len(parent))), testSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "child"+Extension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = NewChildWriter(f, p, 2*testSectorSize, 2*testSectorSize); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -3,6 +3,11 @@ Package wux implements compression of Nintendo Wii-U disc images. The technique
 deduplicates the original disc image on a sector-by-sector basis and relies on
 the fact that despite the disc image being of a fixed size of around 23 GB, the
 majority of that space will be unused.
+
+A child image can additionally reference the sectors of a parent image, which
+is useful for storing regional variants or revisions of the same title that
+share most of their sectors. The parent can be any wud.Reader, including
+another child image, so chains of images can be built.
 */
 package wux
 
@@ -12,6 +17,12 @@ const (
 
 	magic0 uint32 = 0x30585557 // "WUX0"
 	magic1 uint32 = 0x1099d02e
+
+	flagParent     uint32 = 1 << 0 // Image references sectors in a parent image
+	flagIncomplete uint32 = 1 << 1 // Image is still being written
+
+	parentSector    uint32 = 1 << 31    // Index table entry refers to a parent sector
+	unwrittenSector uint32 = 0xffffffff // Index table entry not written yet
 )
 
 // The original tool read/wrote this using fread/fwrite so there's padding involved